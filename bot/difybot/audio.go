@@ -0,0 +1,96 @@
+package difybot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+type audioToTextResponse struct {
+	Text string `json:"text"`
+}
+
+// 文字转语音, 优先使用messageID对应的回答, 返回音频内容, 一般为mp3
+func (client *difyClient) TextToAudio(text, userID, messageID string) ([]byte, error) {
+	payload := map[string]interface{}{
+		"user": userID,
+	}
+	if messageID != "" {
+		payload["message_id"] = messageID
+	} else {
+		payload["text"] = text
+	}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", client.ApiBase+"/text-to-audio", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("text to audio failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// 语音转文字, 需要应用开启语音转文字, 支持mp3, m4a, wav, webm, amr等格式
+func (client *difyClient) AudioToText(userID, fileName string, data []byte) (string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", err
+	}
+	if err = writer.WriteField("user", userID); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", client.ApiBase+"/audio-to-text", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("audio to text failed with status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+	var textResp audioToTextResponse
+	if err = json.Unmarshal(respBody, &textResp); err != nil {
+		return "", err
+	}
+	return textResp.Text, nil
+}
@@ -0,0 +1,90 @@
+package difybot
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxCitations = 3
+	// 引用片段展示的最大字数
+	citationSnippetLength = 80
+)
+
+// 知识库检索到的引用
+type RetrieverResource struct {
+	Position     int
+	DatasetName  string
+	DocumentName string
+	Content      string
+	Score        float64
+}
+
+// 是否展示引用, 通过 SHOW_CITATIONS 配置
+func citationsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SHOW_CITATIONS"))
+	return enabled
+}
+
+// 最多展示的引用数量, 通过 MAX_CITATIONS 配置
+func maxCitations() int {
+	limit, err := strconv.Atoi(os.Getenv("MAX_CITATIONS"))
+	if err != nil || limit <= 0 {
+		return defaultMaxCitations
+	}
+	return limit
+}
+
+// 从message_end的metadata中解析引用
+func ParseRetrieverResources(metadata map[string]interface{}) []RetrieverResource {
+	resources := []RetrieverResource{}
+	items, ok := metadata["retriever_resources"].([]interface{})
+	if !ok {
+		return resources
+	}
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resource := RetrieverResource{}
+		resource.DatasetName, _ = itemMap["dataset_name"].(string)
+		resource.DocumentName, _ = itemMap["document_name"].(string)
+		resource.Content, _ = itemMap["content"].(string)
+		resource.Score, _ = itemMap["score"].(float64)
+		if position, ok := itemMap["position"].(float64); ok {
+			resource.Position = int(position)
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// 生成答案下方的引用来源, 未开启或没有引用时返回空字符串
+func FormatCitations(metadata map[string]interface{}) string {
+	if !citationsEnabled() {
+		return ""
+	}
+	resources := ParseRetrieverResources(metadata)
+	if len(resources) == 0 {
+		return ""
+	}
+	if limit := maxCitations(); len(resources) > limit {
+		resources = resources[:limit]
+	}
+	var builder strings.Builder
+	builder.WriteString("\n\n---\n\n**引用来源**\n\n")
+	for i, resource := range resources {
+		builder.WriteString(fmt.Sprintf("%d. **%s** (相关度 %.2f)\n", i+1, resource.DocumentName, resource.Score))
+		snippet := strings.Join(strings.Fields(resource.Content), " ")
+		if runes := []rune(snippet); len(runes) > citationSnippetLength {
+			snippet = string(runes[:citationSnippetLength]) + "…"
+		}
+		if snippet != "" {
+			builder.WriteString(fmt.Sprintf("> %s\n\n", snippet))
+		}
+	}
+	return builder.String()
+}
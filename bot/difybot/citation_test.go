@@ -0,0 +1,92 @@
+package difybot
+
+import (
+	"strings"
+	"testing"
+)
+
+// 生成n条引用的metadata
+func testCitationMetadata(n int) map[string]interface{} {
+	resources := []interface{}{}
+	for i := 1; i <= n; i++ {
+		resources = append(resources, map[string]interface{}{
+			"position":      float64(i),
+			"dataset_name":  "产品手册",
+			"document_name": "文档" + string(rune('A'+i-1)) + ".md",
+			"content":       "第" + string(rune('0'+i)) + "段\n  内容",
+			"score":         0.9 - float64(i)/10,
+		})
+	}
+	return map[string]interface{}{"retriever_resources": resources}
+}
+
+func TestFormatCitations(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		show     string
+		max      string
+		metadata map[string]interface{}
+		want     string
+	}{
+		{name: "disabled by default", metadata: testCitationMetadata(1), want: ""},
+		{name: "disabled", show: "false", metadata: testCitationMetadata(1), want: ""},
+		{name: "no resources", show: "true", metadata: map[string]interface{}{}, want: ""},
+		{
+			name:     "single",
+			show:     "true",
+			metadata: testCitationMetadata(1),
+			want:     "\n\n---\n\n**引用来源**\n\n1. **文档A.md** (相关度 0.80)\n> 第1段 内容\n\n",
+		},
+		{
+			name:     "default limit",
+			show:     "true",
+			metadata: testCitationMetadata(5),
+			want: "\n\n---\n\n**引用来源**\n\n" +
+				"1. **文档A.md** (相关度 0.80)\n> 第1段 内容\n\n" +
+				"2. **文档B.md** (相关度 0.70)\n> 第2段 内容\n\n" +
+				"3. **文档C.md** (相关度 0.60)\n> 第3段 内容\n\n",
+		},
+		{
+			name:     "custom limit",
+			show:     "1",
+			max:      "2",
+			metadata: testCitationMetadata(5),
+			want: "\n\n---\n\n**引用来源**\n\n" +
+				"1. **文档A.md** (相关度 0.80)\n> 第1段 内容\n\n" +
+				"2. **文档B.md** (相关度 0.70)\n> 第2段 内容\n\n",
+		},
+		{
+			name:     "invalid limit uses default",
+			show:     "true",
+			max:      "0",
+			metadata: testCitationMetadata(4),
+			want: "\n\n---\n\n**引用来源**\n\n" +
+				"1. **文档A.md** (相关度 0.80)\n> 第1段 内容\n\n" +
+				"2. **文档B.md** (相关度 0.70)\n> 第2段 内容\n\n" +
+				"3. **文档C.md** (相关度 0.60)\n> 第3段 内容\n\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SHOW_CITATIONS", tc.show)
+			t.Setenv("MAX_CITATIONS", tc.max)
+			if got := FormatCitations(tc.metadata); got != tc.want {
+				t.Errorf("FormatCitations() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatCitationsSnippet(t *testing.T) {
+	t.Setenv("SHOW_CITATIONS", "true")
+	t.Setenv("MAX_CITATIONS", "")
+	metadata := map[string]interface{}{"retriever_resources": []interface{}{
+		map[string]interface{}{"document_name": "长文档", "content": strings.Repeat("字", 100), "score": 0.5},
+		map[string]interface{}{"document_name": "空文档", "content": "  ", "score": 0.4},
+	}}
+	want := "\n\n---\n\n**引用来源**\n\n" +
+		"1. **长文档** (相关度 0.50)\n> " + strings.Repeat("字", citationSnippetLength) + "…\n\n" +
+		"2. **空文档** (相关度 0.40)\n"
+	if got := FormatCitations(metadata); got != want {
+		t.Errorf("FormatCitations() = %q, want %q", got, want)
+	}
+}
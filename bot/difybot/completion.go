@@ -0,0 +1,58 @@
+package difybot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// 文本生成应用的默认输入模板, {{query}} 会替换为用户的消息
+	defaultCompletionInputsTemplate = `{"query":"{{query}}"}`
+	completionQueryPlaceholder      = "{{query}}"
+)
+
+type CompletionRequestBody struct {
+	Inputs       map[string]interface{} `json:"inputs"`
+	ResponseMode string                 `json:"response_mode"`
+	User         string                 `json:"user"`
+	Files        []FileInput            `json:"files,omitempty"`
+}
+
+// 将对话请求转换为文本生成请求, 每条消息都是一次独立的生成
+// 输入变量由 COMPLETION_INPUTS_TEMPLATE 模板生成
+func buildCompletionRequest(requestBody RequestBody) CompletionRequestBody {
+	inputs := make(map[string]interface{})
+	for k, v := range requestBody.Inputs {
+		inputs[k] = v
+	}
+	for k, v := range completionInputs(requestBody.Query) {
+		inputs[k] = v
+	}
+	return CompletionRequestBody{
+		Inputs:       inputs,
+		ResponseMode: requestBody.ResponseMode,
+		User:         requestBody.User,
+		Files:        requestBody.Files,
+	}
+}
+
+// 用模板生成输入变量
+func completionInputs(query string) map[string]interface{} {
+	template := os.Getenv("COMPLETION_INPUTS_TEMPLATE")
+	if template == "" {
+		template = defaultCompletionInputsTemplate
+	}
+	inputs := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(template), &inputs); err != nil {
+		fmt.Println("Error parsing COMPLETION_INPUTS_TEMPLATE:", err)
+		return map[string]interface{}{"query": query}
+	}
+	for k, v := range inputs {
+		if text, ok := v.(string); ok {
+			inputs[k] = strings.ReplaceAll(text, completionQueryPlaceholder, query)
+		}
+	}
+	return inputs
+}
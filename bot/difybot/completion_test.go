@@ -0,0 +1,79 @@
+package difybot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompletionInputs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		template string
+		query    string
+		want     map[string]interface{}
+	}{
+		{
+			name:  "default template",
+			query: "写一首诗",
+			want:  map[string]interface{}{"query": "写一首诗"},
+		},
+		{
+			name:     "custom variables",
+			template: `{"topic":"{{query}}","style":"formal"}`,
+			query:    "周报",
+			want:     map[string]interface{}{"topic": "周报", "style": "formal"},
+		},
+		{
+			name:     "placeholder inside text",
+			template: `{"prompt":"请翻译：{{query}}（{{query}}）"}`,
+			query:    "hello",
+			want:     map[string]interface{}{"prompt": "请翻译：hello（hello）"},
+		},
+		{
+			name:     "non-string values kept",
+			template: `{"query":"{{query}}","max_words":200,"formal":true}`,
+			query:    "总结",
+			want:     map[string]interface{}{"query": "总结", "max_words": float64(200), "formal": true},
+		},
+		{
+			name:     "query with quotes",
+			template: `{"query":"{{query}}"}`,
+			query:    `说 "你好"`,
+			want:     map[string]interface{}{"query": `说 "你好"`},
+		},
+		{
+			name:     "invalid template",
+			template: `{"query":`,
+			query:    "写一首诗",
+			want:     map[string]interface{}{"query": "写一首诗"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("COMPLETION_INPUTS_TEMPLATE", tc.template)
+			if got := completionInputs(tc.query); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("completionInputs(%q) = %v, want %v", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildCompletionRequest(t *testing.T) {
+	t.Setenv("COMPLETION_INPUTS_TEMPLATE", `{"topic":"{{query}}"}`)
+	files := []FileInput{{Type: "image", TransferMethod: "remote_url", URL: "https://example.com/a.png"}}
+	got := buildCompletionRequest(RequestBody{
+		Inputs:       map[string]interface{}{"name": "张三", "topic": "被模板覆盖"},
+		Query:        "周报",
+		ResponseMode: "streaming",
+		User:         "user1",
+		Files:        files,
+	})
+	want := CompletionRequestBody{
+		Inputs:       map[string]interface{}{"name": "张三", "topic": "周报"},
+		ResponseMode: "streaming",
+		User:         "user1",
+		Files:        files,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildCompletionRequest = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+package difybot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+)
+
+// 知识库文档索引状态
+const (
+	IndexingStatusWaiting   = "waiting"
+	IndexingStatusParsing   = "parsing"
+	IndexingStatusCleaning  = "cleaning"
+	IndexingStatusSplitting = "splitting"
+	IndexingStatusIndexing  = "indexing"
+	IndexingStatusCompleted = "completed"
+	IndexingStatusError     = "error"
+	IndexingStatusPaused    = "paused"
+)
+
+// dify知识库客户端, 知识库接口使用单独的api key
+type datasetClient struct {
+	ApiBase   string
+	ApiKey    string
+	DatasetID string
+}
+
+var DatasetClient datasetClient
+
+type DatasetDocument struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	IndexingStatus string `json:"indexing_status"`
+	Error          string `json:"error"`
+}
+
+type CreateDocumentResponse struct {
+	Document DatasetDocument `json:"document"`
+	Batch    string          `json:"batch"`
+}
+
+type IndexingStatus struct {
+	ID                string `json:"id"`
+	IndexingStatus    string `json:"indexing_status"`
+	CompletedSegments int    `json:"completed_segments"`
+	TotalSegments     int    `json:"total_segments"`
+	Error             string `json:"error"`
+}
+
+type indexingStatusResponse struct {
+	Data []IndexingStatus `json:"data"`
+}
+
+func InitDatasetClient() {
+	DatasetClient = datasetClient{
+		ApiBase:   os.Getenv("API_URL"),
+		ApiKey:    os.Getenv("DIFY_DATASET_API_KEY"),
+		DatasetID: os.Getenv("DIFY_DATASET_ID"),
+	}
+}
+
+// 是否配置了知识库
+func (c *datasetClient) Enabled() bool {
+	return c.ApiKey != "" && c.DatasetID != ""
+}
+
+// 通过文件创建知识库文档, 使用自动分段和高质量索引
+func (c *datasetClient) CreateDocumentByFile(fileName string, data []byte) (*CreateDocumentResponse, error) {
+	processData, err := json.Marshal(map[string]interface{}{
+		"indexing_technique": "high_quality",
+		"process_rule": map[string]interface{}{
+			"mode": "automatic",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err = writer.WriteField("data", string(processData)); err != nil {
+		return nil, err
+	}
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/datasets/%s/document/create-by-file", c.ApiBase, c.DatasetID)
+	respBody, err := c.do("POST", url, writer.FormDataContentType(), body)
+	if err != nil {
+		return nil, err
+	}
+	var createResp CreateDocumentResponse
+	if err = json.Unmarshal(respBody, &createResp); err != nil {
+		return nil, err
+	}
+	return &createResp, nil
+}
+
+// 查询文档索引进度
+func (c *datasetClient) GetIndexingStatus(batch string) (*IndexingStatus, error) {
+	url := fmt.Sprintf("%s/datasets/%s/documents/%s/indexing-status", c.ApiBase, c.DatasetID, batch)
+	respBody, err := c.do("GET", url, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var statusResp indexingStatusResponse
+	if err = json.Unmarshal(respBody, &statusResp); err != nil {
+		return nil, err
+	}
+	if len(statusResp.Data) == 0 {
+		return nil, fmt.Errorf("indexing status of batch %s not found", batch)
+	}
+	return &statusResp.Data[0], nil
+}
+
+func (c *datasetClient) do(method, url, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dataset API request failed with status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
@@ -2,18 +2,16 @@ package difybot
 
 import (
 	"bytes"
-	"context"
 	"ding/utils"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/go-redis/redis/v8"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -23,63 +21,110 @@ type difySession struct {
 }
 
 type difyClient struct {
-	ApiBase     string
-	DifyApiKey  string
-	RedisClient *redis.Client // Redis客户端
-	mu          sync.Mutex    // 保护 Sessions 免受并发访问问题
+	Name       string // 应用名称, 用于路由和区分会话
+	ApiBase    string
+	DifyApiKey string
+	AppType    string         // 应用类型, chat / workflow / completion
+	Store      SessionStore   // 会话存储
+	Parameters *AppParameters // 应用参数, 获取失败时为nil
+	Meta       *AppMeta       // 应用元信息, 获取失败时为nil
 }
 
+// DIFY_APPS 中的应用配置
+type AppConfig struct {
+	Name    string `json:"name"`
+	ApiUrl  string `json:"api_url"`
+	ApiKey  string `json:"api_key"`
+	AppType string `json:"app_type"`
+}
+
+const DefaultAppName = "default"
+
 var DifyClient difyClient
 
+var (
+	// 所有dify应用, 包括默认应用
+	apps = map[string]*difyClient{}
+)
+
 func InitDifyClient() {
 	API_KEY := os.Getenv("API_KEY")
 	API_URL := os.Getenv("API_URL")
-	REDIS_ADDR := os.Getenv("REDIS_ADDR")
-	REDIS_PASSWORD := os.Getenv("REDIS_PASSWORD")
+	store, err := NewSessionStore()
+	if err != nil {
+		panic(err)
+	}
 	DifyClient = difyClient{
+		Name:       DefaultAppName,
 		ApiBase:    API_URL,
 		DifyApiKey: API_KEY,
-		RedisClient: redis.NewClient(&redis.Options{
-			Addr:     REDIS_ADDR,
-			Password: REDIS_PASSWORD,
-			DB:       0, // 使用默认数据库
-		}),
-	}
-	// 检查Redis连接
-	ctx := context.Background()
-	_, err := DifyClient.RedisClient.Ping(ctx).Result()
-	if err != nil {
-		fmt.Println("Error connecting to Redis:", err)
-		os.Exit(1)
+		AppType:    parseAppType(os.Getenv("DIFY_APP_TYPE")),
+		Store:      store,
 	}
+	apps[DefaultAppName] = &DifyClient
 
-	// 清空所有以 $:LWCP_v1 开头的键
-	var cursor uint64
-	var n int
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = DifyClient.RedisClient.Scan(ctx, cursor, "$:LWCP_v1*", 10).Result()
-		if err != nil {
-			fmt.Println("Error scanning keys:", err)
-			os.Exit(1)
+	// 多个dify应用, 格式为JSON数组, 例如 [{"name":"hr","api_url":"...","api_key":"...","app_type":"chat"}]
+	if appsConfig := os.Getenv("DIFY_APPS"); appsConfig != "" {
+		var configs []AppConfig
+		if err := json.Unmarshal([]byte(appsConfig), &configs); err != nil {
+			fmt.Println("Error parsing DIFY_APPS:", err)
 		}
-
-		if len(keys) > 0 {
-			n += len(keys)
-			if _, err := DifyClient.RedisClient.Del(ctx, keys...).Result(); err != nil {
-				fmt.Println("Error deleting keys:", err)
-				os.Exit(1)
+		for _, config := range configs {
+			if config.Name == "" || config.Name == DefaultAppName {
+				fmt.Println("DIFY_APPS 中的应用名称不能为空或default")
+				continue
+			}
+			apiUrl := config.ApiUrl
+			if apiUrl == "" {
+				apiUrl = API_URL
 			}
+			apps[config.Name] = &difyClient{
+				Name:       config.Name,
+				ApiBase:    apiUrl,
+				DifyApiKey: config.ApiKey,
+				AppType:    parseAppType(config.AppType),
+				Store:      store,
+			}
+			fmt.Println("加载dify应用:", config.Name)
 		}
+	}
 
-		if cursor == 0 {
-			break
-		}
+	for _, app := range apps {
+		app.LoadParameters()
 	}
 
-	fmt.Printf("Deleted %d keys\n", n)
+	InitDatasetClient()
+}
+
+// 按名称获取dify应用, 不存在时返回默认应用
+func App(name string) *difyClient {
+	if app, ok := apps[name]; ok {
+		return app
+	}
+	return &DifyClient
+}
+
+// 应用是否存在
+func HasApp(name string) bool {
+	_, ok := apps[name]
+	return ok
+}
+
+// 所有dify应用
+func Apps() []*difyClient {
+	list := make([]*difyClient, 0, len(apps))
+	for _, app := range apps {
+		list = append(list, app)
+	}
+	return list
+}
 
+// 会话在存储中的key, 非默认应用加上应用名前缀, 使各应用的会话互不影响
+func (client *difyClient) storeKey(userID string) string {
+	if client.Name == "" || client.Name == DefaultAppName {
+		return userID
+	}
+	return client.Name + ":" + userID
 }
 
 type RequestBody struct {
@@ -88,6 +133,7 @@ type RequestBody struct {
 	ResponseMode   string                 `json:"response_mode"`
 	ConversationID string                 `json:"conversation_id,omitempty"`
 	User           string                 `json:"user,omitempty"`
+	Files          []FileInput            `json:"files,omitempty"`
 }
 
 type ApiResponse struct {
@@ -114,51 +160,72 @@ type StreamingEvent struct {
 	Answer         string                 `json:"answer,omitempty"`
 	CreatedAt      int64                  `json:"created_at,omitempty"`
 	FinishedAt     int64                  `json:"finished_at,omitempty"`
+	Type           string                 `json:"type,omitempty"`        // message_file事件的文件类型
+	BelongsTo      string                 `json:"belongs_to,omitempty"`  // message_file事件的文件归属, user / assistant
+	URL            string                 `json:"url,omitempty"`         // message_file事件的文件地址
+	Audio          string                 `json:"audio,omitempty"`       // tts_message事件的音频, base64编码的mp3
+	Tool           string                 `json:"tool,omitempty"`        // agent_thought事件调用的工具, 多个工具以;分隔
+	Observation    string                 `json:"observation,omitempty"` // agent_thought事件中工具返回的结果
+}
+
+// 流式响应的处理结果
+type StreamResult struct {
+	Answer         strings.Builder
+	TaskID         string
+	MessageID      string
+	ConversationID string
+	Nodes          []NodeProgress
+	Metadata       map[string]interface{}
+	Files          []MessageFile
+	Audio          bytes.Buffer // 应用开启自动播放时, tts_message事件中的语音
+}
+
+// message_end的metadata中的token用量, 没有用量信息时返回0
+func TotalTokens(metadata map[string]interface{}) int64 {
+	usage, _ := metadata["usage"].(map[string]interface{})
+	total, _ := usage["total_tokens"].(float64)
+	return int64(total)
 }
 
 // 添加会话
 func (client *difyClient) AddSession(userID, conversationID string) {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-
+	if userID == "" || conversationID == "" {
+		// 不需要保存会话的调用不传key
+		return
+	}
+	if client.AppType == AppTypeWorkflow || client.AppType == AppTypeCompletion {
+		// 工作流和文本生成应用没有会话
+		return
+	}
+	ttl := sessionTTL()
 	session := difySession{
 		ConversationID: conversationID,
-		Expiry:         time.Now().Add(30 * time.Minute),
+		Expiry:         time.Now().Add(ttl),
 	}
 
-	// 使用Redis存储会话
-	ctx := context.Background()
 	sessionData, err := json.Marshal(session)
 	if err != nil {
 		fmt.Println("Error marshalling session data:", err)
 		return
 	}
 
-	err = client.RedisClient.Set(ctx, userID, sessionData, 30*time.Minute).Err()
+	err = client.Store.Set(client.storeKey(userID), string(sessionData), ttl)
 	if err != nil {
-		fmt.Println("Error setting session data in Redis:", err)
+		fmt.Println("Error setting session data:", err)
 	}
 
 }
 
 // 获取会话
 func (client *difyClient) GetSession(userID string) (string, bool) {
-	client.mu.Lock()
-	defer client.mu.Unlock()
-
-	// 从Redis获取会话
-	ctx := context.Background()
-	sessionData, err := client.RedisClient.Get(ctx, userID).Result()
-	if err == redis.Nil {
+	sessionData, exists := client.Store.Get(client.storeKey(userID))
+	if !exists {
 		// 会话不存在
 		return "", false
-	} else if err != nil {
-		fmt.Println("Error getting session data from Redis:", err)
-		return "", false
 	}
 
 	var session difySession
-	err = json.Unmarshal([]byte(sessionData), &session)
+	err := json.Unmarshal([]byte(sessionData), &session)
 	if err != nil {
 		fmt.Println("Error unmarshalling session data:", err)
 		return "", false
@@ -166,18 +233,68 @@ func (client *difyClient) GetSession(userID string) (string, bool) {
 
 	if time.Now().After(session.Expiry) {
 		// 会话已过期
-		client.RedisClient.Del(ctx, userID)
+		client.Store.Delete(client.storeKey(userID))
 		return "", false
 	}
 	return session.ConversationID, true
 
 }
 
-func (client *difyClient) CallAPIBlock(query, conversationID, userID string) (string, error) {
+// 删除会话, 下次提问时开启新的dify会话
+func (client *difyClient) DeleteSession(userID string) {
+	if err := client.Store.Delete(client.storeKey(userID)); err != nil {
+		fmt.Println("Error deleting session data:", err)
+	}
+}
+
+// 生成请求的inputs, dify要求inputs不能为null, 未提供的变量使用默认值
+func (client *difyClient) requestInputs(inputs map[string]interface{}) map[string]interface{} {
+	requestInputs := make(map[string]interface{})
+	for k, v := range inputs {
+		requestInputs[k] = v
+	}
+	client.fillInputDefaults(requestInputs)
+	return requestInputs
+}
+
+// 根据应用类型选择接口
+func (client *difyClient) endpoint() string {
+	switch client.AppType {
+	case AppTypeWorkflow:
+		return "/workflows/run"
+	case AppTypeCompletion:
+		return "/completion-messages"
+	default:
+		return "/chat-messages"
+	}
+}
+
+// 根据应用类型生成请求体
+func (client *difyClient) requestPayload(requestBody RequestBody) interface{} {
+	switch client.AppType {
+	case AppTypeWorkflow:
+		return buildWorkflowRequest(requestBody)
+	case AppTypeCompletion:
+		return buildCompletionRequest(requestBody)
+	default:
+		return requestBody
+	}
+}
+
+func (client *difyClient) CallAPIBlock(query, conversationID, userID, sessionKey string, inputs map[string]interface{}) (string, error) {
+	response, err := client.CallAPIBlockResponse(query, conversationID, userID, sessionKey, inputs)
+	if err != nil {
+		return "", err
+	}
+	return response.Answer, nil
+}
+
+// 阻塞调用, 返回完整的响应, 包括metadata
+func (client *difyClient) CallAPIBlockResponse(query, conversationID, userID, sessionKey string, inputs map[string]interface{}) (*ApiResponse, error) {
 
 	// 构建请求体
 	requestBody := RequestBody{
-		Inputs:         make(map[string]interface{}),
+		Inputs:         client.requestInputs(inputs),
 		Query:          query,
 		ResponseMode:   "blocking",
 		ConversationID: conversationID,
@@ -185,15 +302,15 @@ func (client *difyClient) CallAPIBlock(query, conversationID, userID string) (st
 	}
 
 	// 将请求体转换为JSON
-	jsonData, err := json.Marshal(requestBody)
+	jsonData, err := json.Marshal(client.requestPayload(requestBody))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 创建HTTP请求
-	req, err := http.NewRequest("POST", client.ApiBase+"/chat-messages", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", client.ApiBase+client.endpoint(), bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 设置请求头
@@ -204,50 +321,66 @@ func (client *difyClient) CallAPIBlock(query, conversationID, userID string) (st
 	clientHTTP := &http.Client{}
 	resp, err := clientHTTP.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// 读取响应
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 检查响应状态码
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status: %d, response: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("API request failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	if client.AppType == AppTypeWorkflow {
+		var workflowResponse WorkflowResponse
+		if err = json.Unmarshal(body, &workflowResponse); err != nil {
+			fmt.Println("【CallAPI】转换异常", err)
+			return nil, err
+		}
+		if workflowResponse.Data.Error != "" {
+			return nil, fmt.Errorf("workflow run failed: %s", workflowResponse.Data.Error)
+		}
+		return &ApiResponse{
+			TaskID: workflowResponse.TaskID,
+			ID:     workflowResponse.WorkflowRunID,
+			Answer: client.RenderWorkflowOutputs(workflowResponse.Data.Outputs),
+		}, nil
 	}
 	var response ApiResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		fmt.Println("【CallAPI】转换异常", err)
-		return "", err
+		return nil, err
 	}
-	client.AddSession(userID, response.ConversationID)
-	return response.Answer, nil
+	client.AddSession(sessionKey, response.ConversationID)
+	return &response, nil
 }
 
-func (client *difyClient) CallAPIStreaming(query, userID string, conversationID string, permission int) (*http.Response, error) {
+func (client *difyClient) CallAPIStreaming(query, userID string, conversationID string, inputs map[string]interface{}, files []FileInput, permission int) (*http.Response, error) {
 
 	// 初始化客户端
 	clientHttp := &http.Client{}
 	// 构建请求体
 	requestBody := RequestBody{
-		Inputs:         make(map[string]interface{}),
+		Inputs:         client.requestInputs(inputs),
 		Query:          query,
 		ResponseMode:   "streaming",
 		ConversationID: conversationID,
 		User:           userID,
+		Files:          files,
 	}
 
 	// 将请求体转换为JSON
-	jsonData, err := json.Marshal(requestBody)
+	jsonData, err := json.Marshal(client.requestPayload(requestBody))
 	if err != nil {
 		return nil, err
 	}
 	// 创建请求
-	req, err := http.NewRequest("POST", client.ApiBase+"/chat-messages", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", client.ApiBase+client.endpoint(), bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Println("Error creating request:", err)
 		return nil, err
@@ -285,14 +418,24 @@ func (client *difyClient) CallAPIStreaming(query, userID string, conversationID
 	return resp, nil
 
 }
-func (client *difyClient) ProcessEvent(userID string, event StreamingEvent, answerBuilder *strings.Builder, cm *utils.ChannelManager) error {
+func (client *difyClient) ProcessEvent(sessionKey string, event StreamingEvent, result *StreamResult, cm *utils.ChannelManager) error {
 	//println(event.Event)
+	answerBuilder := &result.Answer
+	if event.TaskID != "" {
+		result.TaskID = event.TaskID
+	}
+	if event.MessageID != "" {
+		result.MessageID = event.MessageID
+	}
+	if event.ConversationID != "" {
+		result.ConversationID = event.ConversationID
+	}
 	switch event.Event {
 	case "message":
 		{
 			answerBuilder.WriteString(event.Answer)
 			select {
-			case cm.DataCh <- answerBuilder.String():
+			case cm.DataCh <- result.DisplayContent():
 				time.Sleep(10)
 			default:
 			}
@@ -301,7 +444,7 @@ func (client *difyClient) ProcessEvent(userID string, event StreamingEvent, answ
 		{
 			answerBuilder.WriteString(event.Answer)
 			select {
-			case cm.DataCh <- answerBuilder.String():
+			case cm.DataCh <- result.DisplayContent():
 				time.Sleep(10)
 			default:
 			}
@@ -310,7 +453,28 @@ func (client *difyClient) ProcessEvent(userID string, event StreamingEvent, answ
 		{
 			// 发送停止信号
 			cm.CloseChannel()
-			client.AddSession(userID, event.ConversationID)
+			result.Metadata = event.Metadata
+			client.AddSession(sessionKey, event.ConversationID)
+		}
+	case "message_file":
+		{
+			// 工具生成的图片等文件, 用户上传的文件不需要回复
+			if event.BelongsTo != "user" && event.URL != "" {
+				result.Files = append(result.Files, MessageFile{
+					ID:   event.ID,
+					Type: event.Type,
+					URL:  client.AbsoluteURL(event.URL),
+				})
+			}
+		}
+	case "tts_message":
+		{
+			audio, err := base64.StdEncoding.DecodeString(event.Audio)
+			if err != nil {
+				fmt.Println("Error decoding tts audio:", err)
+				break
+			}
+			result.Audio.Write(audio)
 		}
 	case "message_replace":
 		{
@@ -328,18 +492,90 @@ func (client *difyClient) ProcessEvent(userID string, event StreamingEvent, answ
 		}
 	case "workflow_finished":
 		{
-
+			if client.AppType == AppTypeWorkflow {
+				// 工作流应用以workflow_finished结束, 没有流式文本时展示输出变量
+				if errMsg, _ := event.Data["error"].(string); errMsg != "" {
+					cm.CloseChannel()
+					return errors.New(errMsg)
+				}
+				if answerBuilder.Len() == 0 {
+					outputs, _ := event.Data["outputs"].(map[string]interface{})
+					answerBuilder.WriteString(client.RenderWorkflowOutputs(outputs))
+				}
+				cm.CloseChannel()
+			}
+		}
+	case "text_chunk":
+		{
+			// 工作流应用的流式文本
+			text, _ := event.Data["text"].(string)
+			answerBuilder.WriteString(text)
+			select {
+			case cm.DataCh <- result.DisplayContent():
+			default:
+			}
+		}
+	case "agent_thought":
+		{
+			// agent应用调用工具, 答案输出前展示调用的工具
+			client.agentThought(result, event)
+			client.pushProgress(result, cm)
 		}
 	case "node_started":
 		{
-
+			result.nodeStarted(event.Data)
+			client.pushProgress(result, cm)
 		}
 	case "node_finished":
 		{
-
+			result.nodeFinished(event.Data)
+			client.pushProgress(result, cm)
 		}
 
 	}
 	return nil
 
 }
+
+// 答案输出前推送节点进度
+func (client *difyClient) pushProgress(result *StreamResult, cm *utils.ChannelManager) {
+	if result.Answer.Len() > 0 || cm.IsClosed() {
+		return
+	}
+	select {
+	case cm.DataCh <- result.ProgressMarkdown():
+	default:
+	}
+}
+
+// 停止流式响应
+func (client *difyClient) StopTask(taskID, userID string) error {
+	jsonData, err := json.Marshal(map[string]string{"user": userID})
+	if err != nil {
+		return err
+	}
+	stopPath := "/chat-messages/%s/stop"
+	switch client.AppType {
+	case AppTypeWorkflow:
+		stopPath = "/workflows/tasks/%s/stop"
+	case AppTypeCompletion:
+		stopPath = "/completion-messages/%s/stop"
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s"+stopPath, client.ApiBase, taskID), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("stop task failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
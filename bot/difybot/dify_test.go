@@ -0,0 +1,22 @@
+package difybot
+
+import "testing"
+
+func TestAddSession(t *testing.T) {
+	for _, appType := range []string{AppTypeChat, AppTypeWorkflow, AppTypeCompletion} {
+		t.Run(appType, func(t *testing.T) {
+			store := NewMemorySessionStore()
+			defer store.Close()
+			client := &difyClient{Name: "test", AppType: appType, Store: store}
+			client.AddSession("user1", "conversation1")
+			conversationID, ok := client.GetSession("user1")
+			// 只有对话应用保存会话
+			if want := appType == AppTypeChat; ok != want {
+				t.Fatalf("GetSession found = %v, want %v", ok, want)
+			}
+			if ok && conversationID != "conversation1" {
+				t.Errorf("GetSession = %q, want conversation1", conversationID)
+			}
+		})
+	}
+}
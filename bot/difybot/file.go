@@ -0,0 +1,111 @@
+package difybot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FileTypeImage    = "image"
+	FileTypeDocument = "document"
+
+	TransferMethodRemoteUrl = "remote_url"
+	TransferMethodLocalFile = "local_file"
+)
+
+var (
+	imageExtensions    = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg"}
+	documentExtensions = []string{".txt", ".md", ".markdown", ".pdf", ".html", ".xlsx", ".xls", ".docx", ".csv", ".eml", ".msg", ".pptx", ".ppt", ".xml", ".epub"}
+)
+
+// 根据文件名判断dify的文件类型, 不支持的类型返回空字符串
+func FileTypeByName(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range imageExtensions {
+		if e == ext {
+			return FileTypeImage
+		}
+	}
+	for _, e := range documentExtensions {
+		if e == ext {
+			return FileTypeDocument
+		}
+	}
+	return ""
+}
+
+// 对话请求中携带的文件
+type FileInput struct {
+	Type           string `json:"type"`
+	TransferMethod string `json:"transfer_method"`
+	URL            string `json:"url,omitempty"`
+	UploadFileID   string `json:"upload_file_id,omitempty"`
+}
+
+// 回答中dify生成的文件, 来自message_file事件
+type MessageFile struct {
+	ID   string
+	Type string
+	URL  string
+}
+
+type UploadFileResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Extension string `json:"extension"`
+	MimeType  string `json:"mime_type"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// 上传文件到dify，返回的文件id可在对话中以 local_file 方式引用
+func (client *difyClient) UploadFile(userID, fileName string, data []byte) (*UploadFileResponse, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.WriteField("user", userID); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", client.ApiBase+"/files/upload", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("upload file failed with status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+	var uploadResp UploadFileResponse
+	if err = json.Unmarshal(respBody, &uploadResp); err != nil {
+		return nil, err
+	}
+	return &uploadResp, nil
+}
@@ -0,0 +1,114 @@
+package difybot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 消息反馈
+const (
+	RatingLike    = "like"
+	RatingDislike = "dislike"
+)
+
+// 会话中的一条历史消息
+type HistoryMessage struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversation_id"`
+	Query          string `json:"query"`
+	Answer         string `json:"answer"`
+	CreatedAt      int64  `json:"created_at"`
+}
+
+type historyMessagesResponse struct {
+	Limit   int              `json:"limit"`
+	HasMore bool             `json:"has_more"`
+	Data    []HistoryMessage `json:"data"`
+}
+
+// 获取会话最近的历史消息, 按时间正序返回
+func (client *difyClient) GetMessages(userID, conversationID string, limit int) ([]HistoryMessage, error) {
+	params := url.Values{}
+	params.Add("user", userID)
+	params.Add("conversation_id", conversationID)
+	params.Add("limit", strconv.Itoa(limit))
+
+	req, err := http.NewRequest("GET", client.ApiBase+"/messages?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	var messagesResp historyMessagesResponse
+	if err = json.Unmarshal(body, &messagesResp); err != nil {
+		return nil, err
+	}
+	return messagesResp.Data, nil
+}
+
+type suggestedQuestionsResponse struct {
+	Result string   `json:"result"`
+	Data   []string `json:"data"`
+}
+
+// 获取回答后的推荐问题, 需要应用开启"回答后的下一步问题建议"
+func (client *difyClient) SuggestedQuestions(messageID, userID string) ([]string, error) {
+	params := url.Values{}
+	params.Add("user", userID)
+
+	var suggestedResp suggestedQuestionsResponse
+	if err := client.getJSON(fmt.Sprintf("/messages/%s/suggested?%s", messageID, params.Encode()), &suggestedResp); err != nil {
+		return nil, err
+	}
+	return suggestedResp.Data, nil
+}
+
+// 对消息点赞或点踩, rating为空时撤销反馈
+func (client *difyClient) MessageFeedback(messageID, rating, userID string) error {
+	var ratingValue interface{}
+	if rating != "" {
+		ratingValue = rating
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"rating": ratingValue,
+		"user":   userID,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/messages/%s/feedbacks", client.ApiBase, messageID), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("message feedback failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package difybot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// dify应用的参数, 来自 /parameters
+type AppParameters struct {
+	OpeningStatement              string                  `json:"opening_statement"`
+	SuggestedQuestions            []string                `json:"suggested_questions"`
+	SuggestedQuestionsAfterAnswer FeatureSwitch           `json:"suggested_questions_after_answer"`
+	SpeechToText                  FeatureSwitch           `json:"speech_to_text"`
+	TextToSpeech                  TextToSpeechSetting     `json:"text_to_speech"`
+	RetrieverResource             FeatureSwitch           `json:"retriever_resource"`
+	UserInputForm                 []map[string]InputField `json:"user_input_form"`
+	FileUpload                    FileUploadSetting       `json:"file_upload"`
+}
+
+type FeatureSwitch struct {
+	Enabled bool `json:"enabled"`
+}
+
+type TextToSpeechSetting struct {
+	Enabled  bool   `json:"enabled"`
+	Voice    string `json:"voice"`
+	Language string `json:"language"`
+}
+
+// 输入表单中的一个变量, 类型为 user_input_form 中的key, 例如 text-input / paragraph / select
+type InputField struct {
+	Label    string      `json:"label"`
+	Variable string      `json:"variable"`
+	Required bool        `json:"required"`
+	Default  interface{} `json:"default"` // number类型的变量默认值为数字
+	Options  []string    `json:"options"`
+}
+
+type FileUploadSetting struct {
+	// 新版dify的文件上传设置
+	Enabled          bool     `json:"enabled"`
+	AllowedFileTypes []string `json:"allowed_file_types"`
+	// 旧版dify只有图片上传设置
+	Image struct {
+		Enabled         bool     `json:"enabled"`
+		NumberLimits    int      `json:"number_limits"`
+		TransferMethods []string `json:"transfer_methods"`
+	} `json:"image"`
+}
+
+// dify应用的元信息, 来自 /meta
+type AppMeta struct {
+	// 工具的图标, 内置工具为图片地址, 自定义工具为 {"background":"...","content":"emoji"}
+	ToolIcons map[string]interface{} `json:"tool_icons"`
+}
+
+// 获取应用参数和元信息并缓存, 失败时不影响使用, 相关功能按未知处理
+func (client *difyClient) LoadParameters() {
+	var parameters AppParameters
+	if err := client.getJSON("/parameters", &parameters); err != nil {
+		fmt.Printf("获取dify应用 %s 的参数失败: %v\n", client.Name, err)
+	} else {
+		client.Parameters = &parameters
+	}
+	var meta AppMeta
+	if err := client.getJSON("/meta", &meta); err != nil {
+		fmt.Printf("获取dify应用 %s 的元信息失败: %v\n", client.Name, err)
+	} else {
+		client.Meta = &meta
+	}
+}
+
+func (client *difyClient) getJSON(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", client.ApiBase+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.DifyApiKey)
+
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request failed with status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// 输入表单中的所有变量
+func (client *difyClient) InputFields() []InputField {
+	if client.Parameters == nil {
+		return nil
+	}
+	fields := []InputField{}
+	for _, item := range client.Parameters.UserInputForm {
+		for _, field := range item {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// 检查必填的输入变量, provided为可以提供的变量, 返回无法满足的变量
+// 有默认值的变量会在请求时自动填充
+func (client *difyClient) MissingInputs(provided []string) []string {
+	missing := []string{}
+	for _, field := range client.InputFields() {
+		if !field.Required || field.hasDefault() {
+			continue
+		}
+		found := false
+		for _, variable := range provided {
+			if variable == field.Variable {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, field.Variable)
+		}
+	}
+	return missing
+}
+
+// 应用自身会提供的输入变量, 工作流应用的消息变量和文本生成应用的模板变量
+func (client *difyClient) ProvidedInputs() []string {
+	provided := []string{}
+	switch client.AppType {
+	case AppTypeWorkflow:
+		provided = append(provided, workflowQueryVariable())
+	case AppTypeCompletion:
+		for variable := range completionInputs("") {
+			provided = append(provided, variable)
+		}
+	}
+	return provided
+}
+
+// 未提供的输入变量使用表单中的默认值
+func (client *difyClient) fillInputDefaults(inputs map[string]interface{}) {
+	for _, field := range client.InputFields() {
+		if !field.hasDefault() {
+			continue
+		}
+		if value, ok := inputs[field.Variable]; !ok || value == "" {
+			inputs[field.Variable] = field.Default
+		}
+	}
+}
+
+func (field InputField) hasDefault() bool {
+	return field.Default != nil && field.Default != ""
+}
+
+// 是否允许上传图片, 参数未知时允许
+func (client *difyClient) ImageUploadEnabled() bool {
+	if client.Parameters == nil {
+		return true
+	}
+	fileUpload := client.Parameters.FileUpload
+	if fileUpload.Image.Enabled {
+		return true
+	}
+	return fileUpload.Enabled && fileUpload.allowType(FileTypeImage)
+}
+
+// 是否允许上传该类型的文件, 参数未知时允许
+func (client *difyClient) FileUploadEnabled(fileType string) bool {
+	if fileType == FileTypeImage {
+		return client.ImageUploadEnabled()
+	}
+	if client.Parameters == nil {
+		return true
+	}
+	fileUpload := client.Parameters.FileUpload
+	return fileUpload.Enabled && fileUpload.allowType(fileType)
+}
+
+func (setting FileUploadSetting) allowType(fileType string) bool {
+	for _, allowed := range setting.AllowedFileTypes {
+		if allowed == fileType || allowed == "custom" {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否开启了回答后的推荐问题, 只有对话应用支持
+func (client *difyClient) SuggestedQuestionsEnabled() bool {
+	if client.AppType != AppTypeChat || client.Parameters == nil {
+		return false
+	}
+	return client.Parameters.SuggestedQuestionsAfterAnswer.Enabled
+}
+
+// 是否开启了文字转语音, 参数未知时按开启处理
+func (client *difyClient) TextToSpeechEnabled() bool {
+	if client.Parameters == nil {
+		return true
+	}
+	return client.Parameters.TextToSpeech.Enabled
+}
+
+// 是否开启了语音转文字, 参数未知时按开启处理
+func (client *difyClient) SpeechToTextEnabled() bool {
+	if client.Parameters == nil {
+		return true
+	}
+	return client.Parameters.SpeechToText.Enabled
+}
+
+// 开场白, 附带开场问题
+func (client *difyClient) OpeningStatement() string {
+	if client.Parameters == nil || client.Parameters.OpeningStatement == "" {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString(client.Parameters.OpeningStatement)
+	if len(client.Parameters.SuggestedQuestions) > 0 {
+		builder.WriteString("\n\n")
+		for _, question := range client.Parameters.SuggestedQuestions {
+			builder.WriteString("- " + question + "\n")
+		}
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+// 工具在卡片上的图标, 使用自定义工具的emoji
+// 卡片进度中不展示图片, 内置工具和元信息未知时使用默认图标
+func (client *difyClient) ToolIcon(tool string) string {
+	if client.Meta != nil {
+		if icon, ok := client.Meta.ToolIcons[tool].(map[string]interface{}); ok {
+			if content, _ := icon["content"].(string); content != "" {
+				return content
+			}
+		}
+	}
+	return defaultToolIcon
+}
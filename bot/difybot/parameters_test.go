@@ -0,0 +1,170 @@
+package difybot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// 解析应用参数的JSON, 与 /parameters 的返回格式一致
+func testParameters(t *testing.T, body string) *AppParameters {
+	var parameters AppParameters
+	if err := json.Unmarshal([]byte(body), &parameters); err != nil {
+		t.Fatalf("Unmarshal parameters: %v", err)
+	}
+	return &parameters
+}
+
+func TestLoadParameters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/parameters":
+			w.Write([]byte(`{"opening_statement":"你好","speech_to_text":{"enabled":true}}`))
+		case "/meta":
+			w.Write([]byte(`{"tool_icons":{"weather":{"background":"#fff","content":"☀️"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &difyClient{Name: "test", ApiBase: server.URL, DifyApiKey: "key"}
+	client.LoadParameters()
+	if client.Parameters == nil || client.Parameters.OpeningStatement != "你好" || !client.Parameters.SpeechToText.Enabled {
+		t.Errorf("Parameters = %+v, want opening statement and speech to text", client.Parameters)
+	}
+	if client.Meta == nil || client.ToolIcon("weather") != "☀️" {
+		t.Errorf("Meta = %+v, want weather icon", client.Meta)
+	}
+
+	// 读取失败时按未知处理
+	failed := &difyClient{Name: "test", ApiBase: server.URL, DifyApiKey: "wrong"}
+	failed.LoadParameters()
+	if failed.Parameters != nil || failed.Meta != nil {
+		t.Errorf("LoadParameters with wrong key = %+v, %+v, want nil", failed.Parameters, failed.Meta)
+	}
+}
+
+func TestMissingInputs(t *testing.T) {
+	client := &difyClient{Parameters: testParameters(t, `{"user_input_form":[
+		{"text-input":{"variable":"name","required":true}},
+		{"select":{"variable":"dept","required":true,"options":["a","b"]}},
+		{"paragraph":{"variable":"note","required":false}},
+		{"number":{"variable":"limit","required":true,"default":10}},
+		{"text-input":{"variable":"lang","required":true,"default":""}}
+	]}`)}
+	for _, tc := range []struct {
+		provided []string
+		want     []string
+	}{
+		{provided: nil, want: []string{"name", "dept", "lang"}},
+		{provided: []string{"name", "lang"}, want: []string{"dept"}},
+		{provided: []string{"name", "dept", "lang", "other"}, want: []string{}},
+	} {
+		if got := client.MissingInputs(tc.provided); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("MissingInputs(%v) = %v, want %v", tc.provided, got, tc.want)
+		}
+	}
+	// 参数未知时不检查
+	if got := (&difyClient{}).MissingInputs(nil); len(got) != 0 {
+		t.Errorf("MissingInputs without parameters = %v, want empty", got)
+	}
+}
+
+func TestFileUploadEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		body  string // 为空时参数未知
+		image bool
+		doc   bool
+		audio bool
+	}{
+		{name: "unknown", image: true, doc: true, audio: true},
+		{name: "disabled", body: `{"file_upload":{"enabled":false,"allowed_file_types":["image","document"]}}`},
+		{name: "legacy image", body: `{"file_upload":{"image":{"enabled":true}}}`, image: true},
+		{name: "allowed types", body: `{"file_upload":{"enabled":true,"allowed_file_types":["document"]}}`, doc: true},
+		{name: "custom", body: `{"file_upload":{"enabled":true,"allowed_file_types":["custom"]}}`, image: true, doc: true, audio: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &difyClient{}
+			if tc.body != "" {
+				client.Parameters = testParameters(t, tc.body)
+			}
+			for fileType, want := range map[string]bool{FileTypeImage: tc.image, FileTypeDocument: tc.doc, "audio": tc.audio} {
+				if got := client.FileUploadEnabled(fileType); got != want {
+					t.Errorf("FileUploadEnabled(%s) = %v, want %v", fileType, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpeningStatement(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "unknown", want: ""},
+		{name: "empty", body: `{"opening_statement":"","suggested_questions":["问题"]}`, want: ""},
+		{name: "statement only", body: `{"opening_statement":"你好，我是助手"}`, want: "你好，我是助手"},
+		{
+			name: "with questions",
+			body: `{"opening_statement":"你好","suggested_questions":["怎么请假？","报销流程"]}`,
+			want: "你好\n\n- 怎么请假？\n- 报销流程",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &difyClient{}
+			if tc.body != "" {
+				client.Parameters = testParameters(t, tc.body)
+			}
+			if got := client.OpeningStatement(); got != tc.want {
+				t.Errorf("OpeningStatement() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpeechToTextEnabled(t *testing.T) {
+	if !(&difyClient{}).SpeechToTextEnabled() {
+		t.Errorf("SpeechToTextEnabled without parameters = false, want true")
+	}
+	for body, want := range map[string]bool{
+		`{}`:                                   false,
+		`{"speech_to_text":{"enabled":false}}`: false,
+		`{"speech_to_text":{"enabled":true}}`:  true,
+	} {
+		client := &difyClient{Parameters: testParameters(t, body)}
+		if got := client.SpeechToTextEnabled(); got != want {
+			t.Errorf("SpeechToTextEnabled(%s) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestToolIcon(t *testing.T) {
+	client := &difyClient{Meta: &AppMeta{ToolIcons: map[string]interface{}{
+		"weather": map[string]interface{}{"background": "#fff", "content": "☀️"},
+		"google":  "https://dify.example.com/console/api/workspaces/current/tool-provider/builtin/google/icon",
+		"empty":   map[string]interface{}{"content": ""},
+	}}}
+	for tool, want := range map[string]string{
+		"weather": "☀️",
+		"google":  defaultToolIcon,
+		"empty":   defaultToolIcon,
+		"unknown": defaultToolIcon,
+	} {
+		if got := client.ToolIcon(tool); got != want {
+			t.Errorf("ToolIcon(%s) = %q, want %q", tool, got, want)
+		}
+	}
+	if got := (&difyClient{}).ToolIcon("weather"); got != defaultToolIcon {
+		t.Errorf("ToolIcon without meta = %q, want %q", got, defaultToolIcon)
+	}
+}
@@ -0,0 +1,136 @@
+package difybot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 工作流节点状态
+const (
+	NodeStatusRunning   = "running"
+	NodeStatusSucceeded = "succeeded"
+	NodeStatusFailed    = "failed"
+	NodeStatusStopped   = "stopped"
+)
+
+// agent应用调用工具时的节点类型
+const NodeTypeTool = "tool"
+
+// 工具没有emoji图标时使用的图标
+const defaultToolIcon = "🔧"
+
+// 不在进度中展示的节点类型
+var hiddenNodeTypes = []string{"start", "end", "answer"}
+
+// 工作流节点的执行进度
+type NodeProgress struct {
+	NodeID   string
+	NodeType string
+	Title    string
+	Status   string
+}
+
+// 节点开始执行
+func (result *StreamResult) nodeStarted(data map[string]interface{}) {
+	nodeType, _ := data["node_type"].(string)
+	for _, hidden := range hiddenNodeTypes {
+		if nodeType == hidden {
+			return
+		}
+	}
+	nodeID, _ := data["node_id"].(string)
+	title, _ := data["title"].(string)
+	result.Nodes = append(result.Nodes, NodeProgress{
+		NodeID:   nodeID,
+		NodeType: nodeType,
+		Title:    title,
+		Status:   NodeStatusRunning,
+	})
+}
+
+// 节点执行结束
+func (result *StreamResult) nodeFinished(data map[string]interface{}) {
+	nodeID, _ := data["node_id"].(string)
+	status, _ := data["status"].(string)
+	// 同一节点在循环中会多次执行, 更新最近的一次
+	if i := result.nodeIndex(nodeID); i >= 0 {
+		result.Nodes[i].Status = status
+	}
+}
+
+// agent应用的一次思考, 调用的每个工具作为一个节点, 有工具结果时调用结束
+// 同一次思考会在调用工具前后各推送一次
+func (client *difyClient) agentThought(result *StreamResult, event StreamingEvent) {
+	if event.Tool == "" {
+		return
+	}
+	status := NodeStatusRunning
+	if event.Observation != "" {
+		status = NodeStatusSucceeded
+	}
+	for _, tool := range strings.Split(event.Tool, ";") {
+		tool = strings.TrimSpace(tool)
+		if tool == "" {
+			continue
+		}
+		nodeID := event.ID + ":" + tool
+		if i := result.nodeIndex(nodeID); i >= 0 {
+			if status == NodeStatusSucceeded {
+				result.Nodes[i].Status = status
+			}
+			continue
+		}
+		result.Nodes = append(result.Nodes, NodeProgress{
+			NodeID:   nodeID,
+			NodeType: NodeTypeTool,
+			Title:    client.ToolIcon(tool) + " " + tool,
+			Status:   status,
+		})
+	}
+}
+
+// 节点最近一次执行的位置, 没有时返回-1
+func (result *StreamResult) nodeIndex(nodeID string) int {
+	for i := len(result.Nodes) - 1; i >= 0; i-- {
+		if result.Nodes[i].NodeID == nodeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// 节点进度的markdown, 包括当前节点和步骤列表
+func (result *StreamResult) ProgressMarkdown() string {
+	if len(result.Nodes) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	current := result.Nodes[len(result.Nodes)-1]
+	if current.Status == NodeStatusRunning {
+		builder.WriteString(fmt.Sprintf("**正在执行: %s**\n\n", current.Title))
+	}
+	for _, node := range result.Nodes {
+		switch node.Status {
+		case NodeStatusSucceeded:
+			builder.WriteString(fmt.Sprintf("- %s ✓\n", node.Title))
+		case NodeStatusFailed, NodeStatusStopped:
+			builder.WriteString(fmt.Sprintf("- %s ✗\n", node.Title))
+		default:
+			builder.WriteString(fmt.Sprintf("- %s…\n", node.Title))
+		}
+	}
+	return builder.String()
+}
+
+// 流式卡片上展示的内容
+// 答案开始输出前展示节点进度, 之后进度折叠为一行
+func (result *StreamResult) DisplayContent() string {
+	answer := result.Answer.String()
+	if answer == "" {
+		return result.ProgressMarkdown()
+	}
+	if len(result.Nodes) == 0 {
+		return answer
+	}
+	return fmt.Sprintf("> 已执行 %d 个步骤\n\n%s", len(result.Nodes), answer)
+}
@@ -0,0 +1,105 @@
+package difybot
+
+import "testing"
+
+func TestProgressMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		events []map[string]interface{} // 带status的为node_finished, 否则为node_started
+		want   string
+	}{
+		{name: "no nodes", want: ""},
+		{
+			name: "running",
+			events: []map[string]interface{}{
+				{"node_id": "1", "node_type": "start", "title": "开始"},
+				{"node_id": "2", "node_type": "knowledge-retrieval", "title": "知识检索"},
+			},
+			want: "**正在执行: 知识检索**\n\n- 知识检索…\n",
+		},
+		{
+			name: "finished and running",
+			events: []map[string]interface{}{
+				{"node_id": "2", "node_type": "knowledge-retrieval", "title": "知识检索"},
+				{"node_id": "2", "status": NodeStatusSucceeded},
+				{"node_id": "3", "node_type": "llm", "title": "生成回答"},
+			},
+			want: "**正在执行: 生成回答**\n\n- 知识检索 ✓\n- 生成回答…\n",
+		},
+		{
+			name: "failed and stopped",
+			events: []map[string]interface{}{
+				{"node_id": "2", "node_type": "http-request", "title": "查询接口"},
+				{"node_id": "2", "status": NodeStatusFailed},
+				{"node_id": "3", "node_type": "llm", "title": "生成回答"},
+				{"node_id": "3", "status": NodeStatusStopped},
+			},
+			want: "- 查询接口 ✗\n- 生成回答 ✗\n",
+		},
+		{
+			name: "loop updates latest run",
+			events: []map[string]interface{}{
+				{"node_id": "4", "node_type": "code", "title": "处理"},
+				{"node_id": "4", "status": NodeStatusSucceeded},
+				{"node_id": "4", "node_type": "code", "title": "处理"},
+				{"node_id": "4", "status": NodeStatusSucceeded},
+				{"node_id": "5", "node_type": "answer", "title": "回复"},
+			},
+			want: "- 处理 ✓\n- 处理 ✓\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := &StreamResult{}
+			for _, data := range tc.events {
+				if _, finished := data["status"]; finished {
+					result.nodeFinished(data)
+				} else {
+					result.nodeStarted(data)
+				}
+			}
+			if got := result.ProgressMarkdown(); got != tc.want {
+				t.Errorf("ProgressMarkdown() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDisplayContent(t *testing.T) {
+	result := &StreamResult{}
+	if got := result.DisplayContent(); got != "" {
+		t.Errorf("DisplayContent() without nodes and answer = %q, want empty", got)
+	}
+	result.nodeStarted(map[string]interface{}{"node_id": "1", "node_type": "llm", "title": "生成回答"})
+	if got, want := result.DisplayContent(), "**正在执行: 生成回答**\n\n- 生成回答…\n"; got != want {
+		t.Errorf("DisplayContent() before answer = %q, want %q", got, want)
+	}
+	result.Answer.WriteString("你好")
+	if got, want := result.DisplayContent(), "> 已执行 1 个步骤\n\n你好"; got != want {
+		t.Errorf("DisplayContent() with answer = %q, want %q", got, want)
+	}
+	// 对话应用没有节点时只展示答案
+	chat := &StreamResult{}
+	chat.Answer.WriteString("你好")
+	if got := chat.DisplayContent(); got != "你好" {
+		t.Errorf("DisplayContent() without nodes = %q, want 你好", got)
+	}
+}
+
+func TestAgentThought(t *testing.T) {
+	client := &difyClient{Meta: &AppMeta{ToolIcons: map[string]interface{}{
+		"weather": map[string]interface{}{"content": "☀️"},
+	}}}
+	result := &StreamResult{}
+	for _, event := range []StreamingEvent{
+		{ID: "t1", Event: "agent_thought"},
+		{ID: "t2", Event: "agent_thought", Tool: "weather;google_search"},
+		{ID: "t2", Event: "agent_thought", Tool: "weather;google_search", Observation: "晴"},
+		{ID: "t3", Event: "agent_thought", Tool: "weather"},
+	} {
+		client.agentThought(result, event)
+	}
+	want := "**正在执行: ☀️ weather**\n\n- ☀️ weather ✓\n- 🔧 google_search ✓\n- ☀️ weather…\n"
+	if got := result.ProgressMarkdown(); got != want {
+		t.Errorf("ProgressMarkdown() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,246 @@
+package difybot
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+	SessionStoreBolt   = "bolt"
+
+	defaultSessionTTL  = 30 * time.Minute
+	defaultSessionFile = "data/session.db"
+	// 过期会话的清理间隔
+	sessionCleanupInterval = time.Minute
+)
+
+// 会话存储, 保存钉钉会话与dify conversation的对应关系, 以及转写任务和限流等状态
+type SessionStore interface {
+	// 获取key对应的值, 不存在或已过期时返回false
+	Get(key string) (string, bool)
+	// 设置key的值, ttl后过期
+	Set(key, value string, ttl time.Duration) error
+	// 删除key
+	Delete(key string) error
+	// 计数器加上delta并返回新值, 计数器不存在时创建, ttl后过期
+	IncrBy(key string, delta int64, ttl time.Duration) (int64, error)
+	// 从每个令牌桶中各取一个令牌, 所有桶都有令牌时才取走
+	// 返回第一个没有令牌的桶的下标, 都取到时返回-1
+	TakeTokens(buckets []TokenBucket) (int, error)
+	// 获取或续期租约, key不存在、已过期或已由owner持有时设置为owner, ttl后过期
+	// 被其他owner持有时返回false, 用于多实例之间分配任务
+	Lease(key, owner string, ttl time.Duration) (bool, error)
+	Close() error
+}
+
+// 根据 SESSION_STORE 创建会话存储
+// 多实例部署时会话、限流和转写任务依赖共享的存储, redis或文件不可用时返回错误
+// SESSION_STORE_FALLBACK=memory 时退回到内存存储
+func NewSessionStore() (SessionStore, error) {
+	storeType := os.Getenv("SESSION_STORE")
+	var store SessionStore
+	var err error
+	switch storeType {
+	case SessionStoreMemory:
+		fmt.Println("使用内存存储会话")
+		return NewMemorySessionStore(), nil
+	case SessionStoreBolt:
+		filePath := os.Getenv("SESSION_FILE")
+		if filePath == "" {
+			filePath = defaultSessionFile
+		}
+		if store, err = NewBoltSessionStore(filePath); err == nil {
+			fmt.Println("使用文件存储会话:", filePath)
+		}
+	default:
+		storeType = SessionStoreRedis
+		store, err = NewRedisSessionStore(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"))
+	}
+	if err == nil {
+		return store, nil
+	}
+	if os.Getenv("SESSION_STORE_FALLBACK") == SessionStoreMemory {
+		fmt.Printf("Error opening %s session store, fallback to memory store: %v\n", storeType, err)
+		return NewMemorySessionStore(), nil
+	}
+	return nil, fmt.Errorf("open %s session store: %w", storeType, err)
+}
+
+// 会话有效期, 通过 SESSION_TTL 配置, 单位分钟
+func sessionTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("SESSION_TTL"))
+	if err != nil || minutes <= 0 {
+		return defaultSessionTTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// 令牌桶, 令牌每秒补充Rate个, 最多Burst个
+type TokenBucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+// 令牌桶从空到满需要的时间, 之后的状态和不存在时相同, 作为key的过期时间
+func tokenBucketTTL(rate float64, burst int) time.Duration {
+	ttl := time.Duration(float64(burst) / rate * float64(time.Second))
+	if ttl < time.Second {
+		return time.Second
+	}
+	return ttl
+}
+
+// 补充令牌后取一个令牌, 返回新的状态和是否取到
+// 状态格式为 "令牌数:更新时间的毫秒时间戳", 与redis脚本中的格式相同
+func takeToken(value string, found bool, rate float64, burst int, now time.Time) (string, bool) {
+	tokens := float64(burst)
+	nowMillis := now.UnixMilli()
+	if found {
+		tokensStr, lastStr, _ := strings.Cut(value, ":")
+		lastTokens, err1 := strconv.ParseFloat(tokensStr, 64)
+		last, err2 := strconv.ParseInt(lastStr, 10, 64)
+		if err1 == nil && err2 == nil {
+			elapsed := math.Max(0, float64(nowMillis-last))
+			tokens = math.Min(float64(burst), lastTokens+elapsed*rate/1000)
+		}
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return strconv.FormatFloat(tokens, 'f', -1, 64) + ":" + strconv.FormatInt(nowMillis, 10), allowed
+}
+
+type memoryEntry struct {
+	Value    string
+	ExpireAt time.Time
+}
+
+// 内存会话存储, 适合单实例的小型部署和本地调试
+type memorySessionStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func NewMemorySessionStore() SessionStore {
+	store := &memorySessionStore{
+		entries: make(map[string]memoryEntry),
+		closeCh: make(chan struct{}),
+	}
+	go store.cleanupLoop()
+	return store
+}
+
+func (s *memorySessionStore) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.ExpireAt) {
+		delete(s.entries, key)
+		return "", false
+	}
+	return entry.Value, true
+}
+
+func (s *memorySessionStore) Set(key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{Value: value, ExpireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memorySessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memorySessionStore) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.ExpireAt) {
+		entry = memoryEntry{Value: "0", ExpireAt: time.Now().Add(ttl)}
+	}
+	value, err := strconv.ParseInt(entry.Value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	value += delta
+	entry.Value = strconv.FormatInt(value, 10)
+	s.entries[key] = entry
+	return value, nil
+}
+
+func (s *memorySessionStore) TakeTokens(buckets []TokenBucket) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	values := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		entry, found := s.entries[bucket.Key]
+		found = found && now.Before(entry.ExpireAt)
+		value, allowed := takeToken(entry.Value, found, bucket.Rate, bucket.Burst, now)
+		if !allowed {
+			return i, nil
+		}
+		values[i] = value
+	}
+	for i, bucket := range buckets {
+		s.entries[bucket.Key] = memoryEntry{Value: values[i], ExpireAt: now.Add(tokenBucketTTL(bucket.Rate, bucket.Burst))}
+	}
+	return -1, nil
+}
+
+func (s *memorySessionStore) Lease(key, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if entry, ok := s.entries[key]; ok && now.Before(entry.ExpireAt) && entry.Value != owner {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{Value: owner, ExpireAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memorySessionStore) Close() error {
+	s.once.Do(func() {
+		close(s.closeCh)
+	})
+	return nil
+}
+
+// 定期清理过期会话
+func (s *memorySessionStore) cleanupLoop() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for key, entry := range s.entries {
+				if now.After(entry.ExpireAt) {
+					delete(s.entries, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package difybot
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var sessionBucket = []byte("sessions")
+
+// 基于bbolt的文件会话存储, 不依赖外部服务且重启后会话不丢失
+type boltSessionStore struct {
+	db      *bolt.DB
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func NewBoltSessionStore(filePath string) (SessionStore, error) {
+	if dir := filepath.Dir(filePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &boltSessionStore{
+		db:      db,
+		closeCh: make(chan struct{}),
+	}
+	go store.cleanupLoop()
+	return store, nil
+}
+
+func (s *boltSessionStore) Get(key string) (string, bool) {
+	var entry memoryEntry
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &entry)
+	})
+	if err != nil {
+		fmt.Println("Error getting session data from file:", err)
+		return "", false
+	}
+	if !found {
+		return "", false
+	}
+	if time.Now().After(entry.ExpireAt) {
+		_ = s.Delete(key)
+		return "", false
+	}
+	return entry.Value, true
+}
+
+func (s *boltSessionStore) Set(key, value string, ttl time.Duration) error {
+	data, err := json.Marshal(memoryEntry{Value: value, ExpireAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *boltSessionStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltSessionStore) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	var value int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		now := time.Now()
+		entry := memoryEntry{Value: "0", ExpireAt: now.Add(ttl)}
+		if data := bucket.Get([]byte(key)); data != nil {
+			var stored memoryEntry
+			if err := json.Unmarshal(data, &stored); err == nil && now.Before(stored.ExpireAt) {
+				entry = stored
+			}
+		}
+		current, err := strconv.ParseInt(entry.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		value = current + delta
+		entry.Value = strconv.FormatInt(value, 10)
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	return value, err
+}
+
+func (s *boltSessionStore) TakeTokens(buckets []TokenBucket) (int, error) {
+	denied := -1
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		now := time.Now()
+		entries := make([][]byte, len(buckets))
+		for i, tb := range buckets {
+			var entry memoryEntry
+			found := false
+			if data := bucket.Get([]byte(tb.Key)); data != nil {
+				found = json.Unmarshal(data, &entry) == nil && now.Before(entry.ExpireAt)
+			}
+			value, allowed := takeToken(entry.Value, found, tb.Rate, tb.Burst, now)
+			if !allowed {
+				denied = i
+				return nil
+			}
+			data, err := json.Marshal(memoryEntry{Value: value, ExpireAt: now.Add(tokenBucketTTL(tb.Rate, tb.Burst))})
+			if err != nil {
+				return err
+			}
+			entries[i] = data
+		}
+		for i, tb := range buckets {
+			if err := bucket.Put([]byte(tb.Key), entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return denied, err
+}
+
+func (s *boltSessionStore) Lease(key, owner string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		now := time.Now()
+		if data := bucket.Get([]byte(key)); data != nil {
+			var entry memoryEntry
+			if json.Unmarshal(data, &entry) == nil && now.Before(entry.ExpireAt) && entry.Value != owner {
+				return nil
+			}
+		}
+		data, err := json.Marshal(memoryEntry{Value: owner, ExpireAt: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		acquired = true
+		return bucket.Put([]byte(key), data)
+	})
+	return acquired && err == nil, err
+}
+
+func (s *boltSessionStore) Close() error {
+	s.once.Do(func() {
+		close(s.closeCh)
+	})
+	return s.db.Close()
+}
+
+// 定期清理过期会话
+func (s *boltSessionStore) cleanupLoop() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.cleanupExpired(time.Now()); err != nil {
+				fmt.Println("Error cleaning expired sessions:", err)
+			}
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// 删除now时已过期的会话
+// 遍历时删除会影响cursor的位置, 可能跳过相邻的key, 先收集过期的key再删除
+func (s *boltSessionStore) cleanupExpired(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionBucket)
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var entry memoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil || now.After(entry.ExpireAt) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+package difybot
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+var (
+	// 计数器加上delta, 新建时设置过期时间
+	incrByScript = redis.NewScript(`
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value
+`)
+	// 多个令牌桶, 状态格式与 takeToken 相同, 时间由调用方传入
+	// ARGV[1]为当前毫秒时间戳, 之后每个桶依次为 rate burst ttl
+	takeTokensScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 3 - 1])
+	local burst = tonumber(ARGV[i * 3])
+	local current = burst
+	local value = redis.call('GET', key)
+	if value then
+		local sep = string.find(value, ':', 1, true)
+		if sep then
+			local last_tokens = tonumber(string.sub(value, 1, sep - 1))
+			local last = tonumber(string.sub(value, sep + 1))
+			if last_tokens and last then
+				current = math.min(burst, last_tokens + math.max(0, now - last) * rate / 1000)
+			end
+		end
+	end
+	if current < 1 then
+		return i - 1
+	end
+	tokens[i] = current - 1
+end
+for i, key in ipairs(KEYS) do
+	redis.call('SET', key, string.format('%.6f', tokens[i]) .. ':' .. ARGV[1], 'PX', ARGV[i * 3 + 1])
+end
+return -1
+`)
+	// 租约不存在或由同一个owner持有时设置并续期
+	leaseScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and current ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+)
+
+// redis会话存储, 多实例部署时共享会话
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func NewRedisSessionStore(addr, password string) (SessionStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       0, // 使用默认数据库
+	})
+	// 检查Redis连接
+	ctx := context.Background()
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	// 清空所有以 $:LWCP_v1 开头的键
+	var cursor uint64
+	var n int
+	for {
+		var keys []string
+		var err error
+		keys, cursor, err = client.Scan(ctx, cursor, "$:LWCP_v1*", 10).Result()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			n += len(keys)
+			if _, err := client.Del(ctx, keys...).Result(); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	fmt.Printf("Deleted %d keys\n", n)
+	return &redisSessionStore{client: client}, nil
+}
+
+func (s *redisSessionStore) Get(key string) (string, bool) {
+	value, err := s.client.Get(context.Background(), key).Result()
+	if err == redis.Nil {
+		// 会话不存在
+		return "", false
+	} else if err != nil {
+		fmt.Println("Error getting session data from Redis:", err)
+		return "", false
+	}
+	return value, true
+}
+
+func (s *redisSessionStore) Set(key, value string, ttl time.Duration) error {
+	return s.client.Set(context.Background(), key, value, ttl).Err()
+}
+
+func (s *redisSessionStore) Delete(key string) error {
+	return s.client.Del(context.Background(), key).Err()
+}
+
+func (s *redisSessionStore) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrByScript.Run(context.Background(), s.client, []string{key}, delta, ttl.Milliseconds()).Int64()
+}
+
+// 令牌桶在redis脚本中原子地更新, 多实例共享限流状态
+func (s *redisSessionStore) TakeTokens(buckets []TokenBucket) (int, error) {
+	if len(buckets) == 0 {
+		return -1, nil
+	}
+	keys := make([]string, len(buckets))
+	args := []interface{}{time.Now().UnixMilli()}
+	for i, bucket := range buckets {
+		keys[i] = bucket.Key
+		args = append(args, bucket.Rate, bucket.Burst, tokenBucketTTL(bucket.Rate, bucket.Burst).Milliseconds())
+	}
+	return takeTokensScript.Run(context.Background(), s.client, keys, args...).Int()
+}
+
+func (s *redisSessionStore) Lease(key, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := leaseScript.Run(context.Background(), s.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	return acquired == 1, err
+}
+
+func (s *redisSessionStore) Close() error {
+	return s.client.Close()
+}
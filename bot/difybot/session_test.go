@@ -0,0 +1,277 @@
+package difybot
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 内存和文件存储, 测试结束时关闭
+func testStores(t *testing.T) map[string]SessionStore {
+	boltStore, err := NewBoltSessionStore(filepath.Join(t.TempDir(), "session.db"))
+	if err != nil {
+		t.Fatalf("NewBoltSessionStore: %v", err)
+	}
+	stores := map[string]SessionStore{
+		SessionStoreMemory: NewMemorySessionStore(),
+		SessionStoreBolt:   boltStore,
+	}
+	t.Cleanup(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	})
+	return stores
+}
+
+func TestNewSessionStoreFallback(t *testing.T) {
+	// 会话文件的目录是一个普通文件, 无法打开
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_STORE", SessionStoreBolt)
+	t.Setenv("SESSION_FILE", filepath.Join(blocker, "session.db"))
+
+	t.Setenv("SESSION_STORE_FALLBACK", "")
+	if store, err := NewSessionStore(); err == nil {
+		store.Close()
+		t.Fatalf("NewSessionStore without fallback succeeded, want error")
+	}
+
+	t.Setenv("SESSION_STORE_FALLBACK", SessionStoreMemory)
+	store, err := NewSessionStore()
+	if err != nil {
+		t.Fatalf("NewSessionStore with fallback: %v", err)
+	}
+	defer store.Close()
+	if _, ok := store.(*memorySessionStore); !ok {
+		t.Errorf("NewSessionStore with fallback = %T, want memory store", store)
+	}
+}
+
+func TestSessionStoreTTL(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Set("short", "a", 50*time.Millisecond); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if err := store.Set("long", "b", time.Hour); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if value, ok := store.Get("short"); !ok || value != "a" {
+				t.Fatalf("Get(short) = %q, %v, want a, true", value, ok)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if _, ok := store.Get("short"); ok {
+				t.Errorf("Get(short) found after ttl")
+			}
+			if value, ok := store.Get("long"); !ok || value != "b" {
+				t.Errorf("Get(long) = %q, %v, want b, true", value, ok)
+			}
+			if err := store.Delete("long"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, ok := store.Get("long"); ok {
+				t.Errorf("Get(long) found after delete")
+			}
+		})
+	}
+}
+
+func TestBoltCleanupExpired(t *testing.T) {
+	store, err := NewBoltSessionStore(filepath.Join(t.TempDir(), "session.db"))
+	if err != nil {
+		t.Fatalf("NewBoltSessionStore: %v", err)
+	}
+	defer store.Close()
+	// 相邻的多个过期key都要删除, key足够多时分布在多个page中
+	var want []string
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		ttl := time.Minute
+		if i%10 == 9 {
+			ttl = time.Hour
+			want = append(want, key)
+		}
+		if err := store.Set(key, key, ttl); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	boltStore := store.(*boltSessionStore)
+	if err := boltStore.cleanupExpired(time.Now().Add(30 * time.Minute)); err != nil {
+		t.Fatalf("cleanupExpired: %v", err)
+	}
+	var keys []string
+	err = boltStore.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("keys after cleanup = %v, want %v", keys, want)
+	}
+}
+
+func TestSessionStoreIncrBy(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, tc := range []struct {
+				delta int64
+				want  int64
+			}{{1, 1}, {5, 6}, {-2, 4}} {
+				got, err := store.IncrBy("counter", tc.delta, time.Hour)
+				if err != nil {
+					t.Fatalf("IncrBy #%d: %v", i, err)
+				}
+				if got != tc.want {
+					t.Errorf("IncrBy #%d = %d, want %d", i, got, tc.want)
+				}
+			}
+			if value, ok := store.Get("counter"); !ok || value != "4" {
+				t.Errorf("Get(counter) = %q, %v, want 4, true", value, ok)
+			}
+
+			// 过期后重新计数
+			if _, err := store.IncrBy("expiring", 3, 50*time.Millisecond); err != nil {
+				t.Fatalf("IncrBy: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if got, err := store.IncrBy("expiring", 1, time.Hour); err != nil || got != 1 {
+				t.Errorf("IncrBy after ttl = %d, %v, want 1", got, err)
+			}
+		})
+	}
+}
+
+func TestSessionStoreTakeTokens(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := TokenBucket{Key: "user", Rate: 0.001, Burst: 3}
+			group := TokenBucket{Key: "group", Rate: 0.001, Burst: 1}
+			if denied, err := store.TakeTokens([]TokenBucket{user, group}); err != nil || denied != -1 {
+				t.Fatalf("TakeTokens = %d, %v, want -1", denied, err)
+			}
+			// 群没有令牌时不消耗发送者的令牌
+			for i := 0; i < 3; i++ {
+				if denied, err := store.TakeTokens([]TokenBucket{user, group}); err != nil || denied != 1 {
+					t.Fatalf("TakeTokens #%d = %d, %v, want 1", i, denied, err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				if denied, err := store.TakeTokens([]TokenBucket{user}); err != nil || denied != -1 {
+					t.Fatalf("TakeTokens(user) #%d = %d, %v, want -1", i, denied, err)
+				}
+			}
+			if denied, err := store.TakeTokens([]TokenBucket{user}); err != nil || denied != 0 {
+				t.Errorf("TakeTokens(user) = %d, %v, want 0", denied, err)
+			}
+		})
+	}
+}
+
+func TestSessionStoreLease(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if ok, err := store.Lease("job", "replica1", 50*time.Millisecond); err != nil || !ok {
+				t.Fatalf("Lease(replica1) = %v, %v, want true", ok, err)
+			}
+			if ok, err := store.Lease("job", "replica2", time.Hour); err != nil || ok {
+				t.Fatalf("Lease(replica2) while held = %v, %v, want false", ok, err)
+			}
+			// 持有者可以续期
+			if ok, err := store.Lease("job", "replica1", 50*time.Millisecond); err != nil || !ok {
+				t.Fatalf("renew Lease(replica1) = %v, %v, want true", ok, err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			// 过期后其他实例可以获取
+			if ok, err := store.Lease("job", "replica2", time.Hour); err != nil || !ok {
+				t.Fatalf("Lease(replica2) after ttl = %v, %v, want true", ok, err)
+			}
+			if ok, err := store.Lease("job", "replica1", time.Hour); err != nil || ok {
+				t.Errorf("Lease(replica1) after takeover = %v, %v, want false", ok, err)
+			}
+			if value, ok := store.Get("job"); !ok || value != "replica2" {
+				t.Errorf("Get(job) = %q, %v, want replica2", value, ok)
+			}
+		})
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	const rate, burst = 2.0, 3
+	var value string
+	found := false
+	take := func(now time.Time) bool {
+		var allowed bool
+		value, allowed = takeToken(value, found, rate, burst, now)
+		found = true
+		return allowed
+	}
+
+	// 新的令牌桶是满的, 可以连续取burst个
+	for i := 0; i < burst; i++ {
+		if !take(start) {
+			t.Fatalf("take #%d denied, want allowed", i)
+		}
+	}
+	if take(start) {
+		t.Fatalf("take after burst allowed, want denied")
+	}
+	// 每秒补充rate个令牌
+	if take(start.Add(400 * time.Millisecond)) {
+		t.Errorf("take after 0.4s allowed, want denied")
+	}
+	if !take(start.Add(500 * time.Millisecond)) {
+		t.Errorf("take after 0.5s denied, want allowed")
+	}
+	// 补充的令牌不超过burst
+	later := start.Add(time.Hour)
+	for i := 0; i < burst; i++ {
+		if !take(later) {
+			t.Fatalf("take #%d after refill denied, want allowed", i)
+		}
+	}
+	if take(later) {
+		t.Errorf("take beyond burst after refill allowed, want denied")
+	}
+	// 时钟回拨时不补充也不出错
+	if take(later.Add(-time.Minute)) {
+		t.Errorf("take with earlier clock allowed, want denied")
+	}
+}
+
+func TestTakeTokenInvalidState(t *testing.T) {
+	value, allowed := takeToken("invalid", true, 1, 2, time.UnixMilli(1000))
+	if !allowed {
+		t.Fatalf("takeToken with invalid state denied, want allowed")
+	}
+	if value != "1:1000" {
+		t.Errorf("takeToken value = %q, want 1:1000", value)
+	}
+}
+
+func TestTokenBucketTTL(t *testing.T) {
+	for _, tc := range []struct {
+		rate  float64
+		burst int
+		want  time.Duration
+	}{
+		{rate: 1, burst: 10, want: 10 * time.Second},
+		{rate: 5.0 / 60, burst: 5, want: time.Minute},
+		{rate: 100, burst: 1, want: time.Second},
+	} {
+		if got := tokenBucketTTL(tc.rate, tc.burst); got != tc.want {
+			t.Errorf("tokenBucketTTL(%g, %d) = %s, want %s", tc.rate, tc.burst, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package difybot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// dify应用类型
+const (
+	AppTypeChat       = "chat"
+	AppTypeWorkflow   = "workflow"
+	AppTypeCompletion = "completion"
+)
+
+const (
+	defaultWorkflowQueryVariable = "query"
+)
+
+type WorkflowRequestBody struct {
+	Inputs       map[string]interface{} `json:"inputs"`
+	ResponseMode string                 `json:"response_mode"`
+	User         string                 `json:"user"`
+	Files        []FileInput            `json:"files,omitempty"`
+}
+
+type WorkflowResponse struct {
+	WorkflowRunID string               `json:"workflow_run_id"`
+	TaskID        string               `json:"task_id"`
+	Data          WorkflowResponseData `json:"data"`
+}
+
+type WorkflowResponseData struct {
+	ID      string                 `json:"id"`
+	Status  string                 `json:"status"`
+	Outputs map[string]interface{} `json:"outputs"`
+	Error   string                 `json:"error"`
+}
+
+// 解析dify应用类型, 默认为对话应用
+func parseAppType(t string) string {
+	switch t {
+	case AppTypeWorkflow, AppTypeCompletion:
+		return t
+	default:
+		return AppTypeChat
+	}
+}
+
+// 将对话请求转换为工作流请求
+// 用户的消息写入 WORKFLOW_QUERY_VARIABLE 指定的输入变量,
+// 配置了 WORKFLOW_FILES_VARIABLE 时文件写入该变量, 否则作为 sys.files 传入
+func buildWorkflowRequest(requestBody RequestBody) WorkflowRequestBody {
+	inputs := make(map[string]interface{})
+	for k, v := range requestBody.Inputs {
+		inputs[k] = v
+	}
+	inputs[workflowQueryVariable()] = requestBody.Query
+
+	workflowRequest := WorkflowRequestBody{
+		Inputs:       inputs,
+		ResponseMode: requestBody.ResponseMode,
+		User:         requestBody.User,
+	}
+	if filesVariable := os.Getenv("WORKFLOW_FILES_VARIABLE"); filesVariable != "" {
+		if len(requestBody.Files) > 0 {
+			inputs[filesVariable] = requestBody.Files
+		}
+	} else {
+		workflowRequest.Files = requestBody.Files
+	}
+	return workflowRequest
+}
+
+// 用户的消息写入的工作流输入变量
+func workflowQueryVariable() string {
+	queryVariable := os.Getenv("WORKFLOW_QUERY_VARIABLE")
+	if queryVariable == "" {
+		queryVariable = defaultWorkflowQueryVariable
+	}
+	return queryVariable
+}
+
+// 将工作流的输出变量渲染为markdown
+// 只有一个文本输出时直接展示文本, 文件输出展示为图片或链接
+func (client *difyClient) RenderWorkflowOutputs(outputs map[string]interface{}) string {
+	if len(outputs) == 0 {
+		return ""
+	}
+	if len(outputs) == 1 {
+		for _, value := range outputs {
+			if text, ok := value.(string); ok {
+				return text
+			}
+		}
+	}
+	keys := make([]string, 0, len(outputs))
+	for key := range outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf("**%s**\n\n%s\n\n", key, client.renderOutputValue(outputs[key])))
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+func (client *difyClient) renderOutputValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if file, ok := client.renderOutputFile(v); ok {
+			return file
+		}
+	case []interface{}:
+		files := []string{}
+		for _, item := range v {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				break
+			}
+			file, ok := client.renderOutputFile(itemMap)
+			if !ok {
+				break
+			}
+			files = append(files, file)
+		}
+		if len(files) == len(v) && len(files) > 0 {
+			return strings.Join(files, "\n\n")
+		}
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return "```json\n" + string(data) + "\n```"
+}
+
+// 渲染文件类型的输出变量
+func (client *difyClient) renderOutputFile(file map[string]interface{}) (string, bool) {
+	fileUrl, _ := file["url"].(string)
+	if fileUrl == "" {
+		return "", false
+	}
+	fileUrl = client.AbsoluteURL(fileUrl)
+	fileType, _ := file["type"].(string)
+	fileName, _ := file["filename"].(string)
+	if fileName == "" {
+		fileName = "文件"
+	}
+	if fileType == FileTypeImage {
+		return fmt.Sprintf("![%s](%s)", fileName, fileUrl), true
+	}
+	return fmt.Sprintf("[%s](%s)", fileName, fileUrl), true
+}
+
+// dify返回的文件地址可能是相对路径, 补全为dify服务的地址
+func (client *difyClient) AbsoluteURL(fileUrl string) string {
+	if strings.HasPrefix(fileUrl, "http://") || strings.HasPrefix(fileUrl, "https://") {
+		return fileUrl
+	}
+	base, err := url.Parse(client.ApiBase)
+	if err != nil {
+		return fileUrl
+	}
+	ref, err := url.Parse(fileUrl)
+	if err != nil {
+		return fileUrl
+	}
+	return base.ResolveReference(ref).String()
+}
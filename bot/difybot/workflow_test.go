@@ -0,0 +1,130 @@
+package difybot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderWorkflowOutputs(t *testing.T) {
+	client := &difyClient{ApiBase: "https://dify.example.com/v1"}
+	for _, tc := range []struct {
+		name    string
+		outputs map[string]interface{}
+		want    string
+	}{
+		{name: "empty", outputs: nil, want: ""},
+		{name: "single text", outputs: map[string]interface{}{"text": "你好"}, want: "你好"},
+		{
+			name:    "single non-text",
+			outputs: map[string]interface{}{"count": float64(3)},
+			want:    "**count**\n\n```json\n3\n```",
+		},
+		{
+			name:    "multiple sorted by key",
+			outputs: map[string]interface{}{"summary": "总结", "answer": "回答"},
+			want:    "**answer**\n\n回答\n\n**summary**\n\n总结",
+		},
+		{
+			name: "image file with relative url",
+			outputs: map[string]interface{}{
+				"text":  "见图",
+				"image": map[string]interface{}{"type": "image", "filename": "chart.png", "url": "/files/chart.png"},
+			},
+			want: "**image**\n\n![chart.png](https://dify.example.com/files/chart.png)\n\n**text**\n\n见图",
+		},
+		{
+			name: "file list",
+			outputs: map[string]interface{}{
+				"files": []interface{}{
+					map[string]interface{}{"type": "document", "filename": "a.pdf", "url": "https://cdn.example.com/a.pdf"},
+					map[string]interface{}{"type": "document", "url": "https://cdn.example.com/b.pdf"},
+				},
+				"text": "附件",
+			},
+			want: "**files**\n\n[a.pdf](https://cdn.example.com/a.pdf)\n\n[文件](https://cdn.example.com/b.pdf)\n\n**text**\n\n附件",
+		},
+		{
+			name: "object without url",
+			outputs: map[string]interface{}{
+				"result": map[string]interface{}{"score": float64(1)},
+				"text":   "ok",
+			},
+			want: "**result**\n\n```json\n{\n  \"score\": 1\n}\n```\n\n**text**\n\nok",
+		},
+		{
+			name: "mixed list",
+			outputs: map[string]interface{}{
+				"items": []interface{}{"a", float64(1)},
+				"text":  "ok",
+			},
+			want: "**items**\n\n```json\n[\n  \"a\",\n  1\n]\n```\n\n**text**\n\nok",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := client.RenderWorkflowOutputs(tc.outputs); got != tc.want {
+				t.Errorf("RenderWorkflowOutputs() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildWorkflowRequest(t *testing.T) {
+	files := []FileInput{{Type: "image", TransferMethod: "remote_url", URL: "https://example.com/a.png"}}
+	request := RequestBody{
+		Inputs:       map[string]interface{}{"name": "张三"},
+		Query:        "你好",
+		ResponseMode: "streaming",
+		User:         "user1",
+		Files:        files,
+	}
+	for _, tc := range []struct {
+		name          string
+		queryVariable string
+		filesVariable string
+		want          WorkflowRequestBody
+	}{
+		{
+			name: "default variables",
+			want: WorkflowRequestBody{
+				Inputs:       map[string]interface{}{"name": "张三", "query": "你好"},
+				ResponseMode: "streaming",
+				User:         "user1",
+				Files:        files,
+			},
+		},
+		{
+			name:          "custom variables",
+			queryVariable: "question",
+			filesVariable: "attachments",
+			want: WorkflowRequestBody{
+				Inputs:       map[string]interface{}{"name": "张三", "question": "你好", "attachments": files},
+				ResponseMode: "streaming",
+				User:         "user1",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WORKFLOW_QUERY_VARIABLE", tc.queryVariable)
+			t.Setenv("WORKFLOW_FILES_VARIABLE", tc.filesVariable)
+			if got := buildWorkflowRequest(request); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("buildWorkflowRequest = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	client := &difyClient{ApiBase: "https://dify.example.com/v1"}
+	for _, tc := range []struct {
+		fileUrl string
+		want    string
+	}{
+		{"https://cdn.example.com/a.png", "https://cdn.example.com/a.png"},
+		{"http://cdn.example.com/a.png", "http://cdn.example.com/a.png"},
+		{"/files/a.png?sign=1", "https://dify.example.com/files/a.png?sign=1"},
+	} {
+		if got := client.AbsoluteURL(tc.fileUrl); got != tc.want {
+			t.Errorf("AbsoluteURL(%q) = %q, want %q", tc.fileUrl, got, tc.want)
+		}
+	}
+}
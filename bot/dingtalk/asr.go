@@ -0,0 +1,100 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	audio "ding/voices"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 语音识别服务
+const (
+	asrProviderBaidu  = "baidu"
+	asrProviderXunfei = "xunfei"
+	asrProviderDify   = "dify"
+)
+
+// 语音识别方式
+const (
+	// 钉钉没有识别结果时才使用语音识别服务
+	asrModeFallback = "fallback"
+	// 优先使用语音识别服务, 失败时使用钉钉的识别结果
+	asrModePrimary = "primary"
+)
+
+// dify的语音转文字
+type difyRecognizer struct {
+	appName string
+	userID  string
+}
+
+func (d *difyRecognizer) Recognize(data []byte, format string) (string, error) {
+	return difybot.App(d.appName).AudioToText(d.userID, "voice."+format, data)
+}
+
+// 初始化 ASR_PROVIDER 指定的语音识别服务
+func initSpeechRecognizer() {
+	if os.Getenv("ASR_PROVIDER") == asrProviderBaidu {
+		audio.BaiduVoiceInit()
+	}
+}
+
+// 是否需要下载语音后自己识别
+func needSpeechRecognition(recognition string) bool {
+	if os.Getenv("ASR_PROVIDER") == "" {
+		return false
+	}
+	return os.Getenv("ASR_MODE") == asrModePrimary || strings.TrimSpace(recognition) == ""
+}
+
+func (msg *DingMessage) speechRecognizer() audio.SpeechRecognizer {
+	switch os.Getenv("ASR_PROVIDER") {
+	case asrProviderBaidu:
+		return audio.BaiduVoicdeCli
+	case asrProviderXunfei:
+		return audio.NewXunfeiRecognizer()
+	case asrProviderDify:
+		// 应用没有开启语音转文字时使用钉钉的识别结果
+		if !difybot.App(msg.AppName).SpeechToTextEnabled() {
+			fmt.Println("dify应用没有开启语音转文字:", msg.AppName)
+			return nil
+		}
+		return &difyRecognizer{appName: msg.AppName, userID: difyUser(msg.Data)}
+	}
+	fmt.Println("不支持的语音识别服务:", os.Getenv("ASR_PROVIDER"))
+	return nil
+}
+
+// 下载语音并识别, 识别失败或没有结果时返回钉钉的识别结果
+func (msg *DingMessage) recognizeVoice() string {
+	recognizer := msg.speechRecognizer()
+	if recognizer == nil {
+		return msg.ReceivedMsgStr
+	}
+	clip, err := audio.DownloadClip(msg.VoiceUrl)
+	if err != nil {
+		fmt.Println("Error downloading voice:", err)
+		return msg.ReceivedMsgStr
+	}
+	// 统一转换为16k单声道wav, 转换失败时使用原始音频
+	data, format := clip.Data, clip.Format
+	if wav, err := clip.WAV16k(); err == nil {
+		data, format = wav, audio.FormatWAV
+	} else {
+		fmt.Printf("Error converting %s voice: %v\n", clip.Codec, err)
+		if format == audio.FormatUnknown {
+			format = audio.FormatAMR
+		}
+	}
+	text, err := recognizer.Recognize(data, format)
+	if err != nil {
+		fmt.Println("Error recognizing voice:", err)
+		return msg.ReceivedMsgStr
+	}
+	if strings.TrimSpace(text) == "" {
+		return msg.ReceivedMsgStr
+	}
+	fmt.Println("语音识别结果:", text)
+	return text
+}
@@ -0,0 +1,327 @@
+package dingbot
+
+import (
+	"context"
+	"ding/bot/difybot"
+	"ding/consts"
+	"encoding/json"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/card"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 卡片按钮的动作
+const (
+	cardActionStop       = "stop"
+	cardActionRegenerate = "regenerate"
+	cardActionLike       = "like"
+	cardActionDislike    = "dislike"
+	// 推荐问题按钮的前缀, 后面是问题的序号
+	cardActionSuggestPrefix = "suggest_"
+)
+
+const (
+	// 卡片状态的保留时间, 超过后按钮不再响应
+	cardSessionTTL = 24 * time.Hour
+)
+
+// 卡片按钮
+type cardButton struct {
+	ID     string
+	Text   string
+	Status string // normal / primary / warning
+}
+
+// 构建标准卡片的数据
+// 卡片内容依次为: 可选的加载动画, 正文, 按钮, 每组按钮占一行
+func buildCardData(loading bool, content string, buttonRows ...[]cardButton) string {
+	contents := []map[string]interface{}{}
+	if loading {
+		contents = append(contents,
+			map[string]interface{}{"type": "markdown", "text": fmt.Sprintf("![loading](%s)", consts.LoadingGifUrl), "id": "text_1693929551595"},
+			map[string]interface{}{"type": "divider", "id": "divider_1693929551595"},
+		)
+	}
+	contents = append(contents, map[string]interface{}{"type": "markdown", "text": content + " ", "id": "markdown_1693929674245"})
+	for i, buttons := range buttonRows {
+		if len(buttons) == 0 {
+			continue
+		}
+		actions := []map[string]interface{}{}
+		for _, button := range buttons {
+			status := button.Status
+			if status == "" {
+				status = "normal"
+			}
+			actions = append(actions, map[string]interface{}{
+				"type":       "button",
+				"label":      map[string]interface{}{"type": "text", "text": button.Text, "id": "label_" + button.ID},
+				"actionType": "request",
+				"status":     status,
+				"id":         button.ID,
+			})
+		}
+		actionId := "action_1693929674245"
+		if i > 0 {
+			actionId = fmt.Sprintf("%s_%d", actionId, i)
+		}
+		contents = append(contents, map[string]interface{}{"type": "action", "actions": actions, "id": actionId})
+	}
+	cardData, err := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{
+			"autoLayout":    true,
+			"enableForward": true,
+		},
+		"contents": contents,
+	})
+	if err != nil {
+		fmt.Println("Error marshalling card data:", err)
+		return ""
+	}
+	return string(cardData)
+}
+
+// 一张流式卡片对应的回答状态, 用于响应卡片按钮
+type cardSession struct {
+	mu        sync.Mutex
+	msg       *DingMessage
+	userID    string
+	taskID    string
+	messageID string
+	answer    string
+	rating    string
+	// 回答后的推荐问题
+	suggestions []string
+	finished    bool
+	stopped     bool
+	createdAt   time.Time
+}
+
+var (
+	// key为卡片的cardBizId
+	cardSessions sync.Map
+)
+
+func newCardSession(msg *DingMessage, userID string) *cardSession {
+	cs := &cardSession{
+		msg:       msg,
+		userID:    userID,
+		createdAt: time.Now(),
+	}
+	cardSessions.Store(msg.CardInstanceId, cs)
+	return cs
+}
+
+func (cs *cardSession) setTaskID(taskID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.taskID == "" {
+		cs.taskID = taskID
+	}
+}
+
+func (cs *cardSession) finish() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.finished = true
+}
+
+// 回答结束, 记录最终答案和dify的消息id
+func (cs *cardSession) complete(answer, messageID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.finished = true
+	cs.answer = answer
+	cs.messageID = messageID
+}
+
+// 记录推荐问题
+func (cs *cardSession) setSuggestions(suggestions []string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.suggestions = suggestions
+}
+
+// 回答结束后的卡片, 带重新生成和点赞点踩按钮, 有推荐问题时每个问题一行按钮
+func (cs *cardSession) finalCardData() string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	buttons := []cardButton{{ID: cardActionRegenerate, Text: "重新生成"}}
+	if cs.messageID != "" {
+		likeButton := cardButton{ID: cardActionLike, Text: "👍"}
+		dislikeButton := cardButton{ID: cardActionDislike, Text: "👎"}
+		if cs.rating == difybot.RatingLike {
+			likeButton.Status = "primary"
+		} else if cs.rating == difybot.RatingDislike {
+			dislikeButton.Status = "primary"
+		}
+		buttons = append(buttons, likeButton, dislikeButton)
+	}
+	buttonRows := [][]cardButton{buttons}
+	for i, suggestion := range cs.suggestions {
+		buttonRows = append(buttonRows, []cardButton{{ID: fmt.Sprintf("%s%d", cardActionSuggestPrefix, i), Text: suggestion}})
+	}
+	return buildCardData(false, cs.answer, buttonRows...)
+}
+
+func (cs *cardSession) isStopped() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.stopped
+}
+
+// 定期清理过期的卡片状态
+func cardSessionCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		cardSessions.Range(func(key, value interface{}) bool {
+			if now.Sub(value.(*cardSession).createdAt) > cardSessionTTL {
+				cardSessions.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// 卡片按钮回调
+func (r *Robot) OnCardCallback(ctx context.Context, request *card.CardRequest) (*card.CardResponse, error) {
+	actionIds := request.CardActionData.CardPrivateData.ActionIdList
+	fmt.Printf("[DingTalk]receive card callback: %s %v\n", request.OutTrackId, actionIds)
+	if len(actionIds) == 0 {
+		return &card.CardResponse{}, nil
+	}
+	value, ok := cardSessions.Load(request.OutTrackId)
+	if !ok {
+		fmt.Println("card session not found:", request.OutTrackId)
+		return &card.CardResponse{}, nil
+	}
+	cs := value.(*cardSession)
+	// 推荐问题和重新生成会再次调用dify, 按点击的人限流
+	var clicker *chatbot.BotCallbackDataModel
+	if strings.HasPrefix(actionIds[0], cardActionSuggestPrefix) || actionIds[0] == cardActionRegenerate {
+		clicker = r.cardClicker(request, cs.msg.Data)
+		if res := checkRateLimit(clicker); res != "" {
+			if err := r.replyRateLimited(ctx, clicker, res); err != nil {
+				fmt.Println("Error replying rate limit:", err)
+			}
+			return &card.CardResponse{}, nil
+		}
+	}
+
+	if strings.HasPrefix(actionIds[0], cardActionSuggestPrefix) {
+		index, err := strconv.Atoi(strings.TrimPrefix(actionIds[0], cardActionSuggestPrefix))
+		if err == nil {
+			cs.ask(index, clicker)
+		}
+		return &card.CardResponse{}, nil
+	}
+	switch actionIds[0] {
+	case cardActionStop:
+		cs.stop()
+	case cardActionRegenerate:
+		cs.regenerate(clicker)
+	case cardActionLike:
+		cs.feedback(difybot.RatingLike)
+	case cardActionDislike:
+		cs.feedback(difybot.RatingDislike)
+	}
+	return &card.CardResponse{}, nil
+}
+
+// 停止生成
+func (cs *cardSession) stop() {
+	cs.mu.Lock()
+	if cs.finished || cs.stopped || cs.taskID == "" {
+		cs.mu.Unlock()
+		return
+	}
+	cs.stopped = true
+	taskID := cs.taskID
+	cs.mu.Unlock()
+
+	if err := difybot.App(cs.msg.AppName).StopTask(taskID, cs.userID); err != nil {
+		fmt.Println("Error stopping dify task:", err)
+	}
+}
+
+// 在同一个会话中重新回答上一个问题, clicker为点击按钮的人, 回答的token计入他的额度
+func (cs *cardSession) regenerate(clicker *chatbot.BotCallbackDataModel) {
+	cs.mu.Lock()
+	if !cs.finished {
+		cs.mu.Unlock()
+		return
+	}
+	cs.mu.Unlock()
+
+	msg := cs.msg
+	msg.Robot.enqueue(&DingMessage{
+		AppName:        msg.AppName,
+		Ctx:            msg.Ctx,
+		Data:           msg.Data,
+		Clicker:        clicker,
+		MsgType:        msg.MsgType,
+		Permission:     msg.Permission,
+		IsGroup:        msg.IsGroup,
+		ReceivedMsgStr: msg.ReceivedMsgStr,
+		ImageCodeList:  msg.ImageCodeList,
+		ImageUrlList:   msg.ImageUrlList,
+		FileName:       msg.FileName,
+		FileUrl:        msg.FileUrl,
+	})
+}
+
+// 将推荐问题作为用户的新消息发送到同一个会话, clicker为点击按钮的人
+func (cs *cardSession) ask(index int, clicker *chatbot.BotCallbackDataModel) {
+	cs.mu.Lock()
+	if index < 0 || index >= len(cs.suggestions) {
+		cs.mu.Unlock()
+		return
+	}
+	question := cs.suggestions[index]
+	cs.mu.Unlock()
+
+	msg := cs.msg
+	msg.Robot.enqueue(&DingMessage{
+		AppName:        msg.AppName,
+		Ctx:            msg.Ctx,
+		Data:           msg.Data,
+		Clicker:        clicker,
+		MsgType:        consts.ReceivedTypeText,
+		Permission:     msg.Permission,
+		IsGroup:        msg.IsGroup,
+		ReceivedMsgStr: question,
+	})
+}
+
+// 点赞或点踩, 再次点击相同按钮时撤销
+func (cs *cardSession) feedback(rating string) {
+	cs.mu.Lock()
+	if !cs.finished || cs.messageID == "" {
+		cs.mu.Unlock()
+		return
+	}
+	if cs.rating == rating {
+		rating = ""
+	}
+	messageID := cs.messageID
+	cs.mu.Unlock()
+
+	// 反馈的user需要与发送消息时的user一致, 默认会话范围下即钉钉发送者
+	if err := difybot.App(cs.msg.AppName).MessageFeedback(messageID, rating, cs.userID); err != nil {
+		fmt.Println("Error sending message feedback:", err)
+		return
+	}
+	cs.mu.Lock()
+	cs.rating = rating
+	cs.mu.Unlock()
+
+	if err := cs.msg.Robot.UpdateDingTalkCard(cs.finalCardData(), cs.msg.CardInstanceId); err != nil {
+		fmt.Println("Error updating DingTalk card:", err)
+	}
+}
@@ -0,0 +1,182 @@
+package dingbot
+
+import (
+	"context"
+	"ding/bot/difybot"
+	"ding/consts"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 聊天指令, 以 / 开头的消息在发给dify之前由指令处理
+type Command interface {
+	// 指令名称, 例如 /new
+	Name() string
+	// /help 中展示的说明
+	Help() string
+	// 执行指令, 返回回复给用户的内容
+	Execute(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error)
+}
+
+var (
+	commandMu    sync.RWMutex
+	commands     = map[string]Command{}
+	commandNames []string
+)
+
+// 注册指令, aliases 为指令的别名
+func RegisterCommand(cmd Command, aliases ...string) {
+	commandMu.Lock()
+	defer commandMu.Unlock()
+	if _, exists := commands[cmd.Name()]; !exists {
+		commandNames = append(commandNames, cmd.Name())
+	}
+	commands[cmd.Name()] = cmd
+	for _, alias := range aliases {
+		commands[alias] = cmd
+	}
+}
+
+// 查找消息对应的指令, 不是指令时返回nil
+func matchCommand(text string) (Command, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return nil, ""
+	}
+	name, args, _ := strings.Cut(text, " ")
+	commandMu.RLock()
+	defer commandMu.RUnlock()
+	cmd, ok := commands[strings.ToLower(name)]
+	if !ok {
+		return nil, ""
+	}
+	return cmd, strings.TrimSpace(args)
+}
+
+// 处理指令消息并回复, 返回false表示不是指令, 需要继续交给dify
+// 指令可通过 RobotFromContext 获取接收消息的机器人
+func (r *Robot) handleCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, text string) (bool, error) {
+	cmd, args := matchCommand(text)
+	if cmd == nil {
+		return false, nil
+	}
+	ctx = context.WithValue(ctx, robotContextKey{}, r)
+	fmt.Printf("[DingTalk]receive command: %s %s\n", cmd.Name(), args)
+	res, err := cmd.Execute(ctx, data, args)
+	if err != nil {
+		fmt.Println("Error executing command:", err)
+		res = "指令执行失败: " + err.Error()
+	}
+	if res == "" {
+		return true, nil
+	}
+	replier := chatbot.NewChatbotReplier()
+	return true, replier.SimpleReplyMarkdown(ctx, data.SessionWebhook, []byte(cmd.Name()), []byte(res))
+}
+
+// 函数形式的指令
+type commandFunc struct {
+	name    string
+	help    string
+	execute func(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error)
+}
+
+func (c *commandFunc) Name() string {
+	return c.name
+}
+
+func (c *commandFunc) Help() string {
+	return c.help
+}
+
+func (c *commandFunc) Execute(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	return c.execute(ctx, data, args)
+}
+
+// 用函数创建指令
+func NewCommand(name, help string, execute func(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error)) Command {
+	return &commandFunc{name: name, help: help, execute: execute}
+}
+
+func init() {
+	RegisterCommand(NewCommand("/new", "开启新的对话，清空上下文", newConversationCommand), "/reset")
+	RegisterCommand(NewCommand("/history", "查看当前对话最近的消息，可指定条数，例如 /history 10", historyCommand))
+	RegisterCommand(NewCommand("/whoami", "查看自己的用户信息和当前会话", whoamiCommand))
+	RegisterCommand(NewCommand("/quota", "查看今天的额度，管理员可以用 /quota reset staffId 重置额度", quotaCommand))
+	RegisterCommand(NewCommand(consts.IngestCommand, "下一个发送的文件写入知识库", ingestCommand))
+	RegisterCommand(NewCommand("/help", "查看所有指令", helpCommand))
+}
+
+func newConversationCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	// 清空所有应用的会话
+	key := sessionKey(data)
+	for _, app := range difybot.Apps() {
+		app.DeleteSession(key)
+	}
+	// 发送当前应用的开场白
+	appName, _ := RobotFromContext(ctx).routeApp(data, "")
+	if opening := difybot.App(appName).OpeningStatement(); opening != "" {
+		return "已开启新的对话\n\n" + opening, nil
+	}
+	return "已开启新的对话", nil
+}
+
+func historyCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	limit := 5
+	if args != "" {
+		if _, err := fmt.Sscanf(args, "%d", &limit); err != nil || limit <= 0 || limit > 20 {
+			return "条数需要在1到20之间", nil
+		}
+	}
+	appName, _ := RobotFromContext(ctx).routeApp(data, "")
+	app := difybot.App(appName)
+	conversationID, exists := app.GetSession(sessionKey(data))
+	if !exists {
+		return "当前没有进行中的对话", nil
+	}
+	messages, err := app.GetMessages(difyUser(data), conversationID, limit)
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "当前对话还没有消息", nil
+	}
+	var builder strings.Builder
+	for _, message := range messages {
+		createdAt := time.Unix(message.CreatedAt, 0).Format("01-02 15:04")
+		builder.WriteString(fmt.Sprintf("**[%s] 问:** %s\n\n**答:** %s\n\n---\n\n", createdAt, message.Query, message.Answer))
+	}
+	return builder.String(), nil
+}
+
+func whoamiCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	key := sessionKey(data)
+	appName, _ := RobotFromContext(ctx).routeApp(data, "")
+	conversationID, exists := difybot.App(appName).GetSession(key)
+	if !exists {
+		conversationID = "无"
+	}
+	return fmt.Sprintf("- 昵称: %s\n- staffId: %s\n- senderId: %s\n- 会话范围: %s\n- 会话key: %s\n- dify应用: %s\n- dify会话: %s",
+		data.SenderNick, data.SenderStaffId, data.SenderId, sessionScope(), key, appName, conversationID), nil
+}
+
+func ingestCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	if !difybot.DatasetClient.Enabled() {
+		return "未配置知识库，无法入库", nil
+	}
+	armIngest(data.SenderId)
+	return "请在5分钟内发送需要写入知识库的文件", nil
+}
+
+func helpCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	commandMu.RLock()
+	defer commandMu.RUnlock()
+	var builder strings.Builder
+	for _, name := range commandNames {
+		builder.WriteString(fmt.Sprintf("- **%s** %s\n", name, commands[name].Help()))
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,87 @@
+package dingbot
+
+import (
+	"context"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"strings"
+	"testing"
+)
+
+// 测试结束后恢复注册的指令
+func restoreCommands(t *testing.T) {
+	commandMu.Lock()
+	oldCommands := make(map[string]Command, len(commands))
+	for name, cmd := range commands {
+		oldCommands[name] = cmd
+	}
+	oldNames := append([]string(nil), commandNames...)
+	commandMu.Unlock()
+	t.Cleanup(func() {
+		commandMu.Lock()
+		defer commandMu.Unlock()
+		commands, commandNames = oldCommands, oldNames
+	})
+}
+
+func echoCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	return args, nil
+}
+
+func TestMatchCommand(t *testing.T) {
+	restoreCommands(t)
+	RegisterCommand(NewCommand("/echo", "原样回复", echoCommand), "/e", "/回声")
+	for _, tc := range []struct {
+		text     string
+		wantName string
+		wantArgs string
+	}{
+		{text: "/echo", wantName: "/echo"},
+		{text: "/echo hello world", wantName: "/echo", wantArgs: "hello world"},
+		{text: "  /echo   hello  ", wantName: "/echo", wantArgs: "hello"},
+		{text: "/ECHO hi", wantName: "/echo", wantArgs: "hi"},
+		{text: "/e hi", wantName: "/echo", wantArgs: "hi"},
+		{text: "/回声 你好", wantName: "/echo", wantArgs: "你好"},
+		{text: "/reset", wantName: "/new"},
+		{text: "/history 10", wantName: "/history", wantArgs: "10"},
+		{text: "/unknown hi"},
+		{text: "/echohi"},
+		{text: "echo hi"},
+		{text: "请执行 /echo"},
+		{text: ""},
+	} {
+		cmd, args := matchCommand(tc.text)
+		name := ""
+		if cmd != nil {
+			name = cmd.Name()
+		}
+		if name != tc.wantName || args != tc.wantArgs {
+			t.Errorf("matchCommand(%q) = %q, %q, want %q, %q", tc.text, name, args, tc.wantName, tc.wantArgs)
+		}
+	}
+}
+
+func TestRegisterCommandReplaces(t *testing.T) {
+	restoreCommands(t)
+	RegisterCommand(NewCommand("/echo", "原样回复", echoCommand))
+	RegisterCommand(NewCommand("/echo", "原样回复参数", echoCommand))
+	count := 0
+	for _, name := range commandNames {
+		if name == "/echo" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("/echo appears %d times in command names, want 1", count)
+	}
+	help, err := helpCommand(context.Background(), &chatbot.BotCallbackDataModel{}, "")
+	if err != nil {
+		t.Fatalf("helpCommand: %v", err)
+	}
+	if !strings.Contains(help, "- **/echo** 原样回复参数\n") {
+		t.Errorf("help = %q, want the replaced /echo help", help)
+	}
+	// 别名不在帮助中单独列出
+	if strings.Contains(help, "/reset") {
+		t.Errorf("help = %q, want no aliases", help)
+	}
+}
@@ -3,7 +3,6 @@ package dingbot
 import (
 	"context"
 	"ding/bot/difybot"
-	"ding/clients"
 	"ding/consts"
 	selfutils "ding/utils"
 	"encoding/json"
@@ -12,11 +11,8 @@ import (
 	"github.com/alibabacloud-go/dingtalk/robot_1_0"
 	"github.com/alibabacloud-go/tea/tea"
 	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
-	"github.com/open-dingtalk/dingtalk-stream-sdk-go/client"
 	"github.com/open-dingtalk/dingtalk-stream-sdk-go/logger"
-	"github.com/open-dingtalk/dingtalk-stream-sdk-go/utils"
 	"os"
-	"strings"
 	"time"
 )
 
@@ -24,60 +20,61 @@ import (
 func StartDingRobot() {
 
 	DingVarInit()
-	defer DingChannelDestory()
 
 	logger.SetLogger(logger.NewStdTestLogger())
-	clientId := os.Getenv("CLIENT_ID")
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	topic := os.Getenv("Ding_Topic")
-	cli := &client.StreamClient{}
-	if os.Getenv("Output_Type") == consts.OutputTypeText {
-		//纯文本或markdown输出
-		cli = client.NewStreamClient(
-			client.WithAppCredential(client.NewAppCredentialConfig(clientId, clientSecret)),
-			client.WithUserAgent(client.NewDingtalkGoSDKUserAgent()),
-			client.WithSubscription(utils.SubscriptionTypeKCallback, topic, chatbot.NewDefaultChatBotFrameHandler(OnChatReceiveText).OnEventReceived),
-		)
-	} else if os.Getenv("Output_Type") == consts.OutputTypeStream {
-		clients.DingTalkStreamClientInit()
-		// 流式输出
-		cli = client.NewStreamClient(
-			client.WithAppCredential(client.NewAppCredentialConfig(clientId, clientSecret)))
-		cli.RegisterChatBotCallbackRouter(OnChatBotStreamingMessageReceived)
-	} else if os.Getenv("Output_Type") == consts.OutputTypeMarkDown {
-		clients.DingTalkStreamClientInit()
-		// 流式输出
-		cli = client.NewStreamClient(
-			client.WithAppCredential(client.NewAppCredentialConfig(clientId, clientSecret)))
-		cli.RegisterChatBotCallbackRouter(OnChatReceiveMarkDown)
-	}
-	err := cli.Start(context.Background())
-	if err != nil {
-		panic(err)
+	robots := loadRobots()
+	for _, r := range robots {
+		if err := r.start(); err != nil {
+			panic(err)
+		}
 	}
-
-	defer cli.Close()
+	defer func() {
+		for _, r := range robots {
+			r.stop()
+		}
+	}()
+	go watchTranscriptionJobs(robots)
 
 	select {}
 }
 
-func OnChatReceiveText(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
-	replyMsgStr := strings.TrimSpace(data.Text.Content)
+func (r *Robot) OnChatReceiveText(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
+	replyMsgStr := stripAtBot(data, data.Text.Content)
 	replier := chatbot.NewChatbotReplier()
+	if allowed, res := r.checkGroupPolicy(data); !allowed {
+		return []byte(""), r.replyPolicyRejection(ctx, data, res)
+	}
+	if handled, err := r.handleCommand(ctx, data, replyMsgStr); handled {
+		return []byte(""), err
+	}
+
+	replyMsgStr, ok := checkWakeWord(data, consts.ReceivedTypeText, replyMsgStr)
+	if !ok {
+		return []byte(""), nil
+	}
+
+	if res := checkRateLimit(data); res != "" {
+		return []byte(""), r.replyRateLimited(ctx, data, res)
+	}
 
-	conversationID, exists := difybot.DifyClient.GetSession(data.SenderId)
+	appName, replyMsgStr := r.routeApp(data, replyMsgStr)
+	app := difybot.App(appName)
+	key := sessionKey(data)
+	conversationID, exists := app.GetSession(key)
 	if exists {
-		fmt.Println("Conversation ID for user:", data.SenderId, "is", conversationID)
+		fmt.Println("Conversation ID for session:", key, "is", conversationID)
 	} else {
 		conversationID = ""
-		fmt.Println("No conversation ID found for user:", data.SenderId)
+		fmt.Println("No conversation ID found for session:", key)
 	}
 
-	res, err := difybot.DifyClient.CallAPIBlock(replyMsgStr, conversationID, data.SenderId)
+	response, err := app.CallAPIBlockResponse(replyMsgStr, conversationID, difyUser(data), key, r.difyInputs(data))
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
+	recordTokenUsage(data, difybot.TotalTokens(response.Metadata))
+	res := response.Answer
 	fmt.Println(res)
 
 	if err := replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res)); err != nil {
@@ -87,12 +84,15 @@ func OnChatReceiveText(ctx context.Context, data *chatbot.BotCallbackDataModel)
 
 }
 
-func OnChatBotStreamingMessageReceived(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
+func (r *Robot) OnChatBotStreamingMessageReceived(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
 	// create an uniq card id to identify a card instance while updating
 	// see: https://open.dingtalk.com/document/orgapp/robots-send-interactive-cards (cardBizId)
 	// 数据过滤
 	replier := chatbot.NewChatbotReplier()
 	permission := 0
+	if allowed, res := r.checkGroupPolicy(data); !allowed {
+		return []byte(""), r.replyPolicyRejection(ctx, data, res)
+	}
 	if !selfutils.StringInSlice(data.Msgtype, dingSupportType) {
 		res := "不支持的消息格式"
 		if err := replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res)); err != nil {
@@ -110,27 +110,50 @@ func OnChatBotStreamingMessageReceived(ctx context.Context, data *chatbot.BotCal
 	receivedMsgStr := ""
 	imageCodeList := []string{}
 	imageUrlList := []string{}
+	fileName := ""
+	fileUrl := ""
+	voiceUrl := ""
+	ingestToDataset := false
+	transcribe := false
+	wakeWord := false
+	ok := true
 	//robotClient := robot_1_0.Client{}
 
 	switch data.Msgtype {
 	case consts.ReceivedTypeText:
 
-		receivedMsgStr = strings.TrimSpace(data.Text.Content)
+		receivedMsgStr = stripAtBot(data, data.Text.Content)
 		fmt.Printf("[DingTalk]receive text msg: %s\n", receivedMsgStr)
+		if handled, err := r.handleCommand(ctx, data, receivedMsgStr); handled {
+			return []byte(""), err
+		}
+		if receivedMsgStr, ok = checkWakeWord(data, data.Msgtype, receivedMsgStr); !ok {
+			return []byte(""), nil
+		}
 	case consts.ReceivedTypeVoice:
 		fmt.Printf("[DingTalk]receive voice msg: %s\n", data.Content)
 		for key, value := range data.Content.(map[string]interface{}) {
 			if key == "recognition" {
 				recognitionText := value.(string)
 				fmt.Println(recognitionText)
-				//data.Text.Content = recognitionText
-				//if !selfutils.ContainsKeywords(recognitionText, consts.VoicePrefix) {
-				//	return []byte(""), nil
-				//}
 				receivedMsgStr = recognitionText
 
 			}
 		}
+		if needSpeechRecognition(receivedMsgStr) {
+			// 识别完成后再检查唤醒词
+			wakeWord = wakeWordRequired(data, data.Msgtype)
+			// 下载语音, 在消费者中用语音识别服务识别
+			content, _ := data.Content.(map[string]interface{})
+			downloadCode, _ := content["downloadCode"].(string)
+			downloadUrl, err := r.getDownloadUrl(downloadCode)
+			if err != nil {
+				fmt.Println("Error getting voice download url:", err)
+			}
+			voiceUrl = downloadUrl
+		} else if receivedMsgStr, ok = checkWakeWord(data, data.Msgtype, receivedMsgStr); !ok {
+			return []byte(""), nil
+		}
 	case consts.ReceivedTypeImage:
 		fmt.Printf("[DingTalk]receive image msg: %s", receivedMsgStr)
 		for key, value := range data.Content.(map[string]interface{}) {
@@ -139,54 +162,131 @@ func OnChatBotStreamingMessageReceived(ctx context.Context, data *chatbot.BotCal
 				fmt.Println(downloadCode)
 				imageCodeList = append(imageCodeList, downloadCode)
 				// 请求图片Url链接
-				DownloadReq := robot_1_0.RobotMessageFileDownloadRequest{
-					DownloadCode: &downloadCode,
-				}
-				download, err := clients.DingtalkClient1.RobotMessageFileDownload(&DownloadReq)
+				downloadUrl, err := r.getDownloadUrl(downloadCode)
 				if err != nil {
 					return nil, err
 				}
-				fmt.Println(*download.Body.DownloadUrl)
-				if download.Body.DownloadUrl != nil {
-					imageUrlList = append(imageUrlList, *download.Body.DownloadUrl)
+				if downloadUrl != "" {
+					imageUrlList = append(imageUrlList, downloadUrl)
 				}
 			}
 		}
+	case consts.ReceivedTypeRichText:
+		fmt.Printf("[DingTalk]receive richText msg: %v\n", data.Content)
+		segments := parseRichText(data.Content)
+		receivedMsgStr = stripAtBot(data, buildRichTextQuery(segments))
+		if receivedMsgStr, ok = checkWakeWord(data, data.Msgtype, receivedMsgStr); !ok {
+			return []byte(""), nil
+		}
+		for _, segment := range segments {
+			if segment.DownloadCode == "" {
+				continue
+			}
+			imageCodeList = append(imageCodeList, segment.DownloadCode)
+			downloadUrl, err := r.getDownloadUrl(segment.DownloadCode)
+			if err != nil {
+				return nil, err
+			}
+			if downloadUrl != "" {
+				imageUrlList = append(imageUrlList, downloadUrl)
+			}
+		}
+	case consts.ReceivedTypeFile:
+		fmt.Printf("[DingTalk]receive file msg: %v\n", data.Content)
+		content, _ := data.Content.(map[string]interface{})
+		downloadCode, _ := content["downloadCode"].(string)
+		fileName, _ = content["fileName"].(string)
+		ingestToDataset = takeIngest(data.SenderId)
+		// 录音文件在后台转写
+		transcribe = !ingestToDataset && transcriptionEnabled() && isAudioFile(fileName)
+		if !ingestToDataset && !transcribe && difybot.FileTypeByName(fileName) == "" {
+			res := "不支持的文件格式"
+			if err := replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res)); err != nil {
+				return nil, err
+			}
+			return []byte(""), nil
+		}
+		downloadUrl, err := r.getDownloadUrl(downloadCode)
+		if err != nil {
+			return nil, err
+		}
+		fileUrl = downloadUrl
 
 	}
-	// 将消息放入队列
-	messageQueue <- &DingMessage{
-		Ctx:            ctx,
-		Data:           data,
-		MsgType:        data.Msgtype,
-		Permission:     permission,
-		ReceivedMsgStr: receivedMsgStr,
-		IsGroup:        data.ConversationType == "2",
-		ImageCodeList:  imageCodeList,
-		ImageUrlList:   imageUrlList,
+	// 需要唤醒词的语音在识别后确认包含唤醒词时再计入限流
+	if !wakeWord {
+		if res := checkRateLimit(data); res != "" {
+			return []byte(""), r.replyRateLimited(ctx, data, res)
+		}
 	}
+	// 选择处理消息的dify应用, 需要语音识别的消息在识别后按文字重新选择
+	appName, receivedMsgStr := r.routeApp(data, receivedMsgStr)
+	if res := uploadRejection(appName, imageUrlList, fileName, ingestToDataset || transcribe); res != "" {
+		if err := replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res)); err != nil {
+			return nil, err
+		}
+		return []byte(""), nil
+	}
+	// 将消息放入队列
+	r.enqueue(&DingMessage{
+		AppName:         appName,
+		Ctx:             ctx,
+		Data:            data,
+		MsgType:         data.Msgtype,
+		Permission:      permission,
+		ReceivedMsgStr:  receivedMsgStr,
+		IsGroup:         data.ConversationType == "2",
+		ImageCodeList:   imageCodeList,
+		ImageUrlList:    imageUrlList,
+		FileName:        fileName,
+		FileUrl:         fileUrl,
+		VoiceUrl:        voiceUrl,
+		WakeWord:        wakeWord,
+		IngestToDataset: ingestToDataset,
+		Transcribe:      transcribe,
+	})
 
 	return []byte(""), nil
 }
 
-func OnChatReceiveMarkDown(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
+func (r *Robot) OnChatReceiveMarkDown(ctx context.Context, data *chatbot.BotCallbackDataModel) ([]byte, error) {
 
-	replyMsgStr := strings.TrimSpace(data.Text.Content)
+	replyMsgStr := stripAtBot(data, data.Text.Content)
 	replier := chatbot.NewChatbotReplier()
+	if allowed, res := r.checkGroupPolicy(data); !allowed {
+		return []byte(""), r.replyPolicyRejection(ctx, data, res)
+	}
+	if handled, err := r.handleCommand(ctx, data, replyMsgStr); handled {
+		return []byte(""), err
+	}
+
+	replyMsgStr, ok := checkWakeWord(data, consts.ReceivedTypeText, replyMsgStr)
+	if !ok {
+		return []byte(""), nil
+	}
 
-	conversationID, exists := difybot.DifyClient.GetSession(data.SenderId)
+	if res := checkRateLimit(data); res != "" {
+		return []byte(""), r.replyRateLimited(ctx, data, res)
+	}
+
+	appName, replyMsgStr := r.routeApp(data, replyMsgStr)
+	app := difybot.App(appName)
+	key := sessionKey(data)
+	conversationID, exists := app.GetSession(key)
 	if exists {
-		fmt.Println("Conversation ID for user:", data.SenderId, "is", conversationID)
+		fmt.Println("Conversation ID for session:", key, "is", conversationID)
 	} else {
 		conversationID = ""
-		fmt.Println("No conversation ID found for user:", data.SenderId)
+		fmt.Println("No conversation ID found for session:", key)
 	}
 
-	res, err := difybot.DifyClient.CallAPIBlock(replyMsgStr, conversationID, data.SenderId)
+	response, err := app.CallAPIBlockResponse(replyMsgStr, conversationID, difyUser(data), key, r.difyInputs(data))
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
+	recordTokenUsage(data, difybot.TotalTokens(response.Metadata))
+	res := response.Answer + difybot.FormatCitations(response.Metadata)
 	fmt.Println(res)
 	if err := replier.SimpleReplyMarkdown(ctx, data.SessionWebhook, []byte(""), []byte(res)); err != nil {
 		return nil, err
@@ -196,7 +296,45 @@ func OnChatReceiveMarkDown(ctx context.Context, data *chatbot.BotCallbackDataMod
 
 }
 
-func UpdateDingTalkCard(cardData string, cardInstanceId string) error {
+// 回复群聊策略拒绝的原因, 没有原因时不回复
+func (r *Robot) replyPolicyRejection(ctx context.Context, data *chatbot.BotCallbackDataModel, res string) error {
+	if res == "" {
+		return nil
+	}
+	replier := chatbot.NewChatbotReplier()
+	return replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res))
+}
+
+// 检查dify应用是否允许上传消息中的图片和文件, 不允许时返回回复给用户的提示
+// fileHandled为true时文件不会发给dify应用, 例如写入知识库或录音转写
+func uploadRejection(appName string, imageUrlList []string, fileName string, fileHandled bool) string {
+	app := difybot.App(appName)
+	if len(imageUrlList) > 0 && !app.ImageUploadEnabled() {
+		return "当前应用不支持图片，请发送文字消息"
+	}
+	if fileName != "" && !fileHandled && !app.FileUploadEnabled(difybot.FileTypeByName(fileName)) {
+		return "当前应用不支持上传文件"
+	}
+	return ""
+}
+
+// 通过downloadCode获取钉钉消息中文件的下载链接
+func (r *Robot) getDownloadUrl(downloadCode string) (string, error) {
+	DownloadReq := robot_1_0.RobotMessageFileDownloadRequest{
+		DownloadCode: &downloadCode,
+	}
+	download, err := r.Client.RobotMessageFileDownload(&DownloadReq)
+	if err != nil {
+		return "", err
+	}
+	if download.Body.DownloadUrl == nil {
+		return "", nil
+	}
+	fmt.Println(*download.Body.DownloadUrl)
+	return *download.Body.DownloadUrl, nil
+}
+
+func (r *Robot) UpdateDingTalkCard(cardData string, cardInstanceId string) error {
 	//fmt.Println("发送内容:", content)
 
 	timeStart := time.Now()
@@ -204,7 +342,7 @@ func UpdateDingTalkCard(cardData string, cardInstanceId string) error {
 		CardBizId: tea.String(cardInstanceId),
 		CardData:  tea.String(cardData),
 	}
-	_, err := clients.DingtalkClient1.UpdateInteractiveCard(updateRequest)
+	_, err := r.Client.UpdateInteractiveCard(updateRequest)
 	if err != nil {
 		return err
 	}
@@ -212,18 +350,21 @@ func UpdateDingTalkCard(cardData string, cardInstanceId string) error {
 	fmt.Printf("updateDingTalkCard 执行时间: %s\n", elapsed)
 	return nil
 }
-func sendInteractiveCard(cardInstanceId string, msg *DingMessage) {
+func (r *Robot) sendInteractiveCard(cardInstanceId string, msg *DingMessage, cardData string) {
 	// send interactive card; 发送交互式卡片
-	cardData := fmt.Sprintf(consts.MessageCardTemplateWithTitle1, "")
 	sendOptions := &dingtalkim_1_0.SendRobotInteractiveCardRequestSendOptions{}
 	request := &dingtalkim_1_0.SendRobotInteractiveCardRequest{
 		CardTemplateId: tea.String("StandardCard"),
 		CardBizId:      tea.String(cardInstanceId),
 		CardData:       tea.String(cardData),
-		RobotCode:      tea.String(clients.DingtalkClient1.ClientID),
+		RobotCode:      tea.String(r.Client.ClientID),
 		SendOptions:    sendOptions,
 		PullStrategy:   tea.Bool(false),
 	}
+	if routeKey := os.Getenv("CARD_CALLBACK_ROUTE_KEY"); routeKey != "" {
+		// 卡片按钮回调的路由key
+		request.SetCallbackUrl(routeKey)
+	}
 	if msg.IsGroup {
 		// group chat; 群聊
 		fmt.Println("钉钉接收群消息:", msg.Data.Text.Content)
@@ -239,7 +380,7 @@ func sendInteractiveCard(cardInstanceId string, msg *DingMessage) {
 		}
 		request.SetSingleChatReceiver(string(receiverBytes))
 	}
-	_, err := clients.DingtalkClient1.SendInteractiveCard(request)
+	_, err := r.Client.SendInteractiveCard(request)
 	if err != nil {
 		fmt.Println("发送卡片失败")
 		return
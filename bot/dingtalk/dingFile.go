@@ -0,0 +1,138 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	selfutils "ding/utils"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+const (
+	// 发送入库指令后等待文件的时间
+	ingestPendingDuration = 5 * time.Minute
+	// 索引进度轮询间隔与超时时间
+	ingestPollInterval = 3 * time.Second
+	ingestPollTimeout  = 10 * time.Minute
+)
+
+var (
+	// 已发送入库指令的用户, key为SenderId, value为指令过期时间
+	pendingIngest sync.Map
+)
+
+var indexingStatusText = map[string]string{
+	difybot.IndexingStatusWaiting:   "排队中",
+	difybot.IndexingStatusParsing:   "解析中",
+	difybot.IndexingStatusCleaning:  "清洗中",
+	difybot.IndexingStatusSplitting: "分段中",
+	difybot.IndexingStatusIndexing:  "索引中",
+	difybot.IndexingStatusCompleted: "已完成",
+	difybot.IndexingStatusError:     "失败",
+	difybot.IndexingStatusPaused:    "已暂停",
+}
+
+// 标记用户的下一个文件需要写入知识库
+func armIngest(senderId string) {
+	pendingIngest.Store(senderId, time.Now().Add(ingestPendingDuration))
+}
+
+// 取出并清除用户的入库标记
+func takeIngest(senderId string) bool {
+	value, ok := pendingIngest.LoadAndDelete(senderId)
+	if !ok {
+		return false
+	}
+	return time.Now().Before(value.(time.Time))
+}
+
+// 将文件写入dify知识库, 并在卡片上展示索引进度
+func (msg *DingMessage) ingestFile() {
+	u, err := uuid.NewUUID()
+	if err != nil {
+		fmt.Println("生成uuid错误")
+		return
+	}
+	cardInstanceId := u.String()
+	msg.CardInstanceId = cardInstanceId
+	msg.Robot.sendInteractiveCard(cardInstanceId, msg, buildCardData(true, "", nil))
+
+	updateStatus := func(status string) {
+		cardData := buildCardData(false, fmt.Sprintf("**%s**\n\n%s", msg.FileName, status))
+		if err := msg.Robot.UpdateDingTalkCard(cardData, cardInstanceId); err != nil {
+			fmt.Println("Error updating DingTalk card:", err)
+		}
+	}
+
+	if !difybot.DatasetClient.Enabled() {
+		updateStatus("未配置知识库，无法入库")
+		return
+	}
+	updateStatus("文件下载中")
+	data, err := selfutils.DownloadFile(msg.FileUrl)
+	if err != nil {
+		fmt.Println("Error downloading file:", err)
+		updateStatus("文件下载失败")
+		return
+	}
+	updateStatus("文件上传中")
+	createResp, err := difybot.DatasetClient.CreateDocumentByFile(msg.FileName, data)
+	if err != nil {
+		fmt.Println("Error creating dataset document:", err)
+		updateStatus("文件上传知识库失败")
+		return
+	}
+
+	deadline := time.Now().Add(ingestPollTimeout)
+	lastStatus := ""
+	for time.Now().Before(deadline) {
+		status, err := difybot.DatasetClient.GetIndexingStatus(createResp.Batch)
+		if err != nil {
+			fmt.Println("Error getting indexing status:", err)
+			time.Sleep(ingestPollInterval)
+			continue
+		}
+		statusText := indexingStatusText[status.IndexingStatus]
+		if statusText == "" {
+			statusText = status.IndexingStatus
+		}
+		if status.TotalSegments > 0 {
+			statusText = fmt.Sprintf("%s (%d/%d)", statusText, status.CompletedSegments, status.TotalSegments)
+		}
+		if status.IndexingStatus == difybot.IndexingStatusError && status.Error != "" {
+			statusText = fmt.Sprintf("%s: %s", statusText, status.Error)
+		}
+		if statusText != lastStatus {
+			updateStatus("入库状态: " + statusText)
+			lastStatus = statusText
+		}
+		switch status.IndexingStatus {
+		case difybot.IndexingStatusCompleted, difybot.IndexingStatusError, difybot.IndexingStatusPaused:
+			return
+		}
+		time.Sleep(ingestPollInterval)
+	}
+	updateStatus("入库状态: 查询超时，请稍后在dify知识库中查看")
+}
+
+// 下载钉钉文件并上传到dify, 作为当前对话的文件输入
+func (msg *DingMessage) uploadFileToDify(userID string) (*difybot.FileInput, error) {
+	fileType := difybot.FileTypeByName(msg.FileName)
+	if fileType == "" {
+		return nil, fmt.Errorf("unsupported file type: %s", msg.FileName)
+	}
+	data, err := selfutils.DownloadFile(msg.FileUrl)
+	if err != nil {
+		return nil, err
+	}
+	uploadResp, err := difybot.App(msg.AppName).UploadFile(userID, msg.FileName, data)
+	if err != nil {
+		return nil, err
+	}
+	return &difybot.FileInput{
+		Type:           fileType,
+		TransferMethod: difybot.TransferMethodLocalFile,
+		UploadFileID:   uploadResp.ID,
+	}, nil
+}
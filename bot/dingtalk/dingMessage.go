@@ -10,14 +10,17 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
 	"strings"
-	"sync"
 	"time"
 )
 
 type DingMessage struct {
+	Robot            *Robot // 接收消息的机器人
+	AppName          string // 处理消息的dify应用
 	Ctx              context.Context
 	Data             *chatbot.BotCallbackDataModel
+	Clicker          *chatbot.BotCallbackDataModel // 点击卡片按钮触发时为点击的人, 计入他的额度
 	MsgType          string
 	Permission       int
 	IsGroup          bool
@@ -26,38 +29,38 @@ type DingMessage struct {
 	ConversationID   string
 	ImageCodeList    []string
 	ImageUrlList     []string
+	FileName         string
+	FileUrl          string
+	VoiceUrl         string // 需要语音识别服务识别的语音
+	WakeWord         bool   // 语音识别后需要检查唤醒词
+	IngestToDataset  bool
+	Transcribe       bool // 录音文件, 在后台转写
 	ProcessStartTime time.Time
 	ProcessEndTime   time.Time
 	ProcessDurTime   time.Duration
 }
 
+const (
+	// dify流式响应中单行事件的最大长度
+	maxStreamLineSize = 16 * 1024 * 1024
+)
+
 var (
-	messageQueue    chan *DingMessage
-	wg              sync.WaitGroup
 	dingSupportType []string
 )
 
 func DingVarInit() {
-	messageQueue = make(chan *DingMessage, 1000) // 设置队列容量
-	dingSupportType = []string{"text", "audio", "picture"}
-
-	numConsumers := 5
-	// 启动多个消费者
-	for i := 0; i < numConsumers; i++ {
-		wg.Add(1)
-		go messageConsumer()
-	}
-}
-func DingChannelDestory() {
-	close(messageQueue)
-}
+	dingSupportType = []string{"text", "audio", "picture", "richText", "file"}
 
-func messageConsumer() {
-	defer wg.Done()
-	for msg := range messageQueue {
-		// 处理消息的逻辑
-		msg.processMessage()
-	}
+	go cardSessionCleanup()
+
+	loadAppRoutes()
+	loadGroupPolicy()
+	loadWakeWord()
+	loadRateLimit()
+	loadInputMapping()
+	validateAppInputs()
+	initSpeechRecognizer()
 }
 
 func (msg *DingMessage) startProcessing() {
@@ -69,21 +72,78 @@ func (msg *DingMessage) endProcessing() {
 	msg.ProcessDurTime = msg.ProcessEndTime.Sub(msg.ProcessStartTime)
 	fmt.Println("Duration:", msg.ProcessDurTime)
 }
+
+// 计入额度的发送者
+func (msg *DingMessage) quotaData() *chatbot.BotCallbackDataModel {
+	if msg.Clicker != nil {
+		return msg.Clicker
+	}
+	return msg.Data
+}
+
+// 语音识别后检查唤醒词, 并按识别出的文字重新选择dify应用
+// 接收消息时还没有文字, 关键词路由在识别后才能生效, 返回false时不回复这条消息
+func (msg *DingMessage) acceptRecognizedVoice() bool {
+	if msg.WakeWord {
+		query, ok := checkWakeWord(msg.Data, msg.MsgType, msg.ReceivedMsgStr)
+		if !ok {
+			return false
+		}
+		msg.ReceivedMsgStr = query
+		// 没有唤醒词的语音不回复, 也不计入限流和额度
+		if res := checkRateLimit(msg.Data); res != "" {
+			if err := msg.Robot.replyRateLimited(msg.Ctx, msg.Data, res); err != nil {
+				fmt.Println("Error replying rate limit:", err)
+			}
+			return false
+		}
+	}
+	msg.AppName, msg.ReceivedMsgStr = msg.Robot.routeApp(msg.Data, msg.ReceivedMsgStr)
+	return true
+}
+
 func (msg *DingMessage) processMessage() {
 	msg.startProcessing()
-	if msg.ReceivedMsgStr != "" {
+	if msg.FileUrl != "" && msg.IngestToDataset {
+		// 文件写入知识库
+		msg.ingestFile()
+		msg.endProcessing()
+		return
+	}
+	if msg.FileUrl != "" && msg.Transcribe {
+		// 录音文件转写
+		msg.startTranscription()
+		msg.endProcessing()
+		return
+	}
+	if msg.VoiceUrl != "" {
+		msg.ReceivedMsgStr = msg.recognizeVoice()
+		if !msg.acceptRecognizedVoice() {
+			msg.endProcessing()
+			return
+		}
+	}
+	if msg.ReceivedMsgStr != "" || len(msg.ImageUrlList) > 0 || msg.FileUrl != "" {
 		// 获取用户sessionId
-		userID := msg.Data.SenderId
-		conversationID, exists := difybot.DifyClient.GetSession(msg.Data.SenderId)
+		userID := difyUser(msg.Data)
+		key := sessionKey(msg.Data)
+		app := difybot.App(msg.AppName)
+		conversationID, exists := app.GetSession(key)
 		if exists {
-			fmt.Println("Conversation ID for user:", userID, "is", conversationID)
+			fmt.Println("Conversation ID for session:", key, "is", conversationID)
 		} else {
 			conversationID = ""
-			fmt.Println("No conversation ID found for user:", userID)
+			fmt.Println("No conversation ID found for session:", key)
 		}
 		msg.ConversationID = conversationID
+		query := msg.ReceivedMsgStr
+		if query == "" && msg.FileUrl != "" {
+			query = consts.DefaultFileQuery
+		} else if query == "" {
+			query = consts.DefaultImageQuery
+		}
 		// 调用dify API 获取工作流
-		difyResp, err := difybot.DifyClient.CallAPIStreaming(msg.ReceivedMsgStr, userID, conversationID, msg.Permission)
+		difyResp, err := app.CallAPIStreaming(query, userID, conversationID, msg.Robot.difyInputs(msg.Data), msg.buildDifyFiles(userID), msg.Permission)
 		if err != nil {
 			fmt.Println("Error CallAPIStreaming:", err)
 			return
@@ -97,8 +157,10 @@ func (msg *DingMessage) processMessage() {
 		}
 		cardInstanceId := u.String()
 		msg.CardInstanceId = cardInstanceId
+		cs := newCardSession(msg, userID)
+		defer cs.finish()
 		// 接收流返回
-		var answerBuilder strings.Builder
+		result := &difybot.StreamResult{}
 		cm := selfutils.NewChannelManager()
 		defer func() {
 			if !cm.IsClosed() {
@@ -120,8 +182,8 @@ func (msg *DingMessage) processMessage() {
 				case <-timer.C:
 					if lastContent != "" {
 						go func(content string) {
-							cardData := fmt.Sprintf(consts.MessageCardTemplateWithTitle1, content)
-							err := UpdateDingTalkCard(cardData, cardInstanceId)
+							cardData := buildCardData(true, content, []cardButton{{ID: cardActionStop, Text: "停止生成", Status: "warning"}})
+							err := msg.Robot.UpdateDingTalkCard(cardData, cardInstanceId)
 							if err != nil {
 								fmt.Println("Error updating DingTalk card:", err)
 							}
@@ -137,8 +199,10 @@ func (msg *DingMessage) processMessage() {
 
 			}
 		}(cm, cardInstanceId)
-		sendInteractiveCard(cardInstanceId, msg)
+		msg.Robot.sendInteractiveCard(cardInstanceId, msg, buildCardData(true, "", []cardButton{{ID: cardActionStop, Text: "停止生成", Status: "warning"}}))
 		streamScanner := bufio.NewScanner(difyResp.Body)
+		// workflow_finished和node_finished事件包含所有输出变量, 可能超过默认的64KB
+		streamScanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
 		for streamScanner.Scan() {
 			var event difybot.StreamingEvent
 			line := streamScanner.Text()
@@ -153,11 +217,17 @@ func (msg *DingMessage) processMessage() {
 				continue
 			}
 
-			err = difybot.DifyClient.ProcessEvent(userID, event, &answerBuilder, cm)
-			if err != nil {
+			eventErr := app.ProcessEvent(key, event, result, cm)
+			cs.setTaskID(result.TaskID)
+			if eventErr != nil {
+				fmt.Println("Error processing event:", eventErr)
+				if !cm.IsClosed() {
+					cm.CloseChannel()
+				}
 				cardData := fmt.Sprintf(consts.MessageCardTemplateWithoutTitle, "服务器内部错误")
-				err = UpdateDingTalkCard(cardData, cardInstanceId)
-				fmt.Printf("processEvent err %s\n", err)
+				if err = msg.Robot.UpdateDingTalkCard(cardData, cardInstanceId); err != nil {
+					fmt.Println("Error updating DingTalk card:", err)
+				}
 				return
 			}
 
@@ -165,20 +235,85 @@ func (msg *DingMessage) processMessage() {
 
 		if err = streamScanner.Err(); err != nil {
 			fmt.Println("Error reading response:", err)
+			if !cm.IsClosed() {
+				cm.CloseChannel()
+			}
+			// 保留已生成的内容, 去掉停止按钮
+			cardData := buildCardData(false, result.Answer.String()+"\n\n（读取回答失败）")
+			if err = msg.Robot.UpdateDingTalkCard(cardData, cardInstanceId); err != nil {
+				fmt.Println("Error updating DingTalk card:", err)
+			}
 			return
 		}
 		if !cm.IsClosed() {
 			cm.CloseChannel()
 		}
-		fmt.Println("Final Answer:", answerBuilder.String())
+		recordTokenUsage(msg.quotaData(), difybot.TotalTokens(result.Metadata))
+		answer := result.Answer.String() + msg.replyFiles(result.Files) + difybot.FormatCitations(result.Metadata)
+		if cs.isStopped() {
+			answer += "\n\n（已停止生成）"
+		}
+		cs.complete(answer, result.MessageID)
+		fmt.Println("Final Answer:", answer)
+		if app.SuggestedQuestionsEnabled() && result.MessageID != "" && !cs.isStopped() {
+			suggestions, err := app.SuggestedQuestions(result.MessageID, userID)
+			if err != nil {
+				fmt.Println("Error getting suggested questions:", err)
+			}
+			cs.setSuggestions(suggestions)
+		}
 		time.Sleep(300)
-		cardData := fmt.Sprintf(consts.MessageCardTemplateWithoutTitle, answerBuilder.String())
-		err = UpdateDingTalkCard(cardData, cardInstanceId)
+		err = msg.Robot.UpdateDingTalkCard(cs.finalCardData(), cardInstanceId)
 		if err != nil {
 			fmt.Println("Error updating DingTalk card:", err)
 		}
+		if msg.voiceReplyEnabled() && !cs.isStopped() && result.Answer.Len() > 0 {
+			if err = msg.replyVoice(userID, result); err != nil {
+				fmt.Println("Error replying voice:", err)
+			}
+		}
 		// 结束处理
 		msg.endProcessing()
 
 	}
 }
+
+// 将钉钉图片转换为dify的文件输入
+// DIFY_IMAGE_TRANSFER=local_file 时先下载图片再上传到dify, 否则直接传递钉钉的图片下载链接
+func (msg *DingMessage) buildDifyFiles(userID string) []difybot.FileInput {
+	files := []difybot.FileInput{}
+	for i, imageUrl := range msg.ImageUrlList {
+		if os.Getenv("DIFY_IMAGE_TRANSFER") != difybot.TransferMethodLocalFile {
+			files = append(files, difybot.FileInput{
+				Type:           difybot.FileTypeImage,
+				TransferMethod: difybot.TransferMethodRemoteUrl,
+				URL:            imageUrl,
+			})
+			continue
+		}
+		data, err := selfutils.DownloadFile(imageUrl)
+		if err != nil {
+			fmt.Println("Error downloading image:", err)
+			continue
+		}
+		uploadResp, err := difybot.App(msg.AppName).UploadFile(userID, fmt.Sprintf("image_%d.png", i), data)
+		if err != nil {
+			fmt.Println("Error uploading image to dify:", err)
+			continue
+		}
+		files = append(files, difybot.FileInput{
+			Type:           difybot.FileTypeImage,
+			TransferMethod: difybot.TransferMethodLocalFile,
+			UploadFileID:   uploadResp.ID,
+		})
+	}
+	if msg.FileUrl != "" {
+		file, err := msg.uploadFileToDify(userID)
+		if err != nil {
+			fmt.Println("Error uploading file to dify:", err)
+		} else {
+			files = append(files, *file)
+		}
+	}
+	return files
+}
@@ -0,0 +1,139 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"encoding/json"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 可以映射到dify输入变量的字段
+const (
+	inputSourceSenderNick        = "senderNick"
+	inputSourceSenderStaffId     = "senderStaffId"
+	inputSourceSenderId          = "senderId"
+	inputSourceConversationTitle = "conversationTitle"
+	inputSourceConversationType  = "conversationType"
+	inputSourceIsAdmin           = "isAdmin"
+	inputSourceChatbotCorpId     = "chatbotCorpId"
+	// 以下字段需要查询通讯录
+	inputSourceName       = "name"
+	inputSourceTitle      = "title"
+	inputSourceJobNumber  = "jobNumber"
+	inputSourceDepartment = "department"
+	inputSourceDeptIds    = "deptIds"
+)
+
+var (
+	// dify输入变量名 -> 字段
+	inputMapping map[string]string
+)
+
+// 加载 DIFY_INPUT_MAPPING, 格式为JSON对象, key为dify输入变量名, value为字段
+// 例如 {"user_name":"senderNick","department":"department","is_admin":"isAdmin"}
+func loadInputMapping() {
+	inputMapping = nil
+	mappingConfig := os.Getenv("DIFY_INPUT_MAPPING")
+	if mappingConfig == "" {
+		return
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(mappingConfig), &mapping); err != nil {
+		fmt.Println("Error parsing DIFY_INPUT_MAPPING:", err)
+		return
+	}
+	inputMapping = mapping
+}
+
+// 检查各dify应用必填的输入变量是否都能提供, 只打印提示, 不影响启动
+func validateAppInputs() {
+	for _, app := range difybot.Apps() {
+		provided := app.ProvidedInputs()
+		for variable := range inputMapping {
+			provided = append(provided, variable)
+		}
+		if missing := app.MissingInputs(provided); len(missing) > 0 {
+			fmt.Printf("dify应用 %s 的必填输入变量 %s 没有配置, 请在 DIFY_INPUT_MAPPING 中添加\n", app.Name, strings.Join(missing, ","))
+		}
+	}
+}
+
+// 是否需要查询通讯录
+func contactFieldMapped() bool {
+	for _, source := range inputMapping {
+		switch source {
+		case inputSourceName, inputSourceTitle, inputSourceJobNumber, inputSourceDepartment, inputSourceDeptIds:
+			return true
+		}
+	}
+	return false
+}
+
+// 根据映射生成dify的输入变量, 值统一为字符串
+// 通讯录查询失败时对应的变量为空字符串
+func (r *Robot) difyInputs(data *chatbot.BotCallbackDataModel) map[string]interface{} {
+	inputs := make(map[string]interface{})
+	if len(inputMapping) == 0 {
+		return inputs
+	}
+	contact := map[string]string{}
+	if contactFieldMapped() {
+		contact = r.senderContact(data)
+	}
+	for variable, source := range inputMapping {
+		switch source {
+		case inputSourceSenderNick:
+			inputs[variable] = data.SenderNick
+		case inputSourceSenderStaffId:
+			inputs[variable] = data.SenderStaffId
+		case inputSourceSenderId:
+			inputs[variable] = data.SenderId
+		case inputSourceConversationTitle:
+			inputs[variable] = data.ConversationTitle
+		case inputSourceConversationType:
+			inputs[variable] = data.ConversationType
+		case inputSourceIsAdmin:
+			inputs[variable] = strconv.FormatBool(data.IsAdmin)
+		case inputSourceChatbotCorpId:
+			inputs[variable] = data.ChatbotCorpId
+		case inputSourceName, inputSourceTitle, inputSourceJobNumber, inputSourceDepartment, inputSourceDeptIds:
+			inputs[variable] = contact[source]
+		default:
+			fmt.Println("DIFY_INPUT_MAPPING 中的字段不支持:", source)
+		}
+	}
+	return inputs
+}
+
+// 查询发送者的通讯录信息, 部门为所有部门名称, 用逗号分隔
+func (r *Robot) senderContact(data *chatbot.BotCallbackDataModel) map[string]string {
+	contact := map[string]string{}
+	if data.SenderStaffId == "" {
+		return contact
+	}
+	info, err := r.contacts.GetUserInfo(data.SenderStaffId)
+	if err != nil {
+		fmt.Println("Error getting user info:", err)
+		return contact
+	}
+	contact[inputSourceName] = info.Name
+	contact[inputSourceTitle] = info.Title
+	contact[inputSourceJobNumber] = info.JobNumber
+	deptIds := make([]string, 0, len(info.DeptIdList))
+	deptNames := make([]string, 0, len(info.DeptIdList))
+	for _, deptId := range info.DeptIdList {
+		deptIds = append(deptIds, strconv.FormatInt(deptId, 10))
+		dept, err := r.contacts.GetDeptInfo(deptId)
+		if err != nil {
+			fmt.Println("Error getting dept info:", err)
+			continue
+		}
+		deptNames = append(deptNames, dept.Name)
+	}
+	contact[inputSourceDeptIds] = strings.Join(deptIds, ",")
+	contact[inputSourceDepartment] = strings.Join(deptNames, ",")
+	return contact
+}
@@ -0,0 +1,158 @@
+package dingbot
+
+import (
+	selfutils "ding/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const (
+	// 所有群使用的规则在 groups 中的key
+	groupPolicyDefaultKey = "*"
+	// 向上查找父部门的最大层数
+	maxDeptDepth = 10
+)
+
+// 群聊策略, 单聊不受限制
+type groupPolicy struct {
+	RequireAt bool                 `json:"require_at"` // 只回复@机器人的消息
+	Allow     []string             `json:"allow"`      // 允许使用的群, 为空时允许所有群
+	Deny      []string             `json:"deny"`       // 禁止使用的群
+	Groups    map[string]groupRule `json:"groups"`     // 按群的ConversationId配置, * 为默认规则
+}
+
+// 单个群的规则, 配置了 admins / staff_ids / dept_ids 时只有满足任一条件的发送者可以使用
+type groupRule struct {
+	RequireAt *bool    `json:"require_at"` // 覆盖全局的 require_at
+	Admins    bool     `json:"admins"`     // 允许企业管理员
+	StaffIds  []string `json:"staff_ids"`  // 允许的员工
+	DeptIds   []int64  `json:"dept_ids"`   // 允许的部门, 包含子部门
+}
+
+var policy groupPolicy
+
+// 加载 GROUP_POLICY 群聊策略, 格式为JSON
+// 例如 {"require_at":true,"deny":["cid..."],"groups":{"cid...":{"admins":true,"dept_ids":[123]}}}
+func loadGroupPolicy() {
+	policy = groupPolicy{}
+	policyConfig := os.Getenv("GROUP_POLICY")
+	if policyConfig == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(policyConfig), &policy); err != nil {
+		fmt.Println("Error parsing GROUP_POLICY:", err)
+		policy = groupPolicy{}
+	}
+}
+
+// 群的规则, 没有单独配置时使用默认规则
+func (p *groupPolicy) rule(conversationId string) groupRule {
+	if rule, ok := p.Groups[conversationId]; ok {
+		return rule
+	}
+	return p.Groups[groupPolicyDefaultKey]
+}
+
+func (rule groupRule) restricted() bool {
+	return rule.Admins || len(rule.StaffIds) > 0 || len(rule.DeptIds) > 0
+}
+
+// 检查群聊消息是否需要处理, 返回false时不处理
+// 没有@机器人或群不允许使用时不回复, 发送者没有权限时返回回复给用户的提示
+func (r *Robot) checkGroupPolicy(data *chatbot.BotCallbackDataModel) (bool, string) {
+	if data.ConversationType != "2" {
+		return true, ""
+	}
+	if selfutils.StringInSlice(data.ConversationId, policy.Deny) ||
+		len(policy.Allow) > 0 && !selfutils.StringInSlice(data.ConversationId, policy.Allow) {
+		fmt.Println("群不允许使用机器人, 忽略:", data.ConversationId, data.ConversationTitle)
+		return false, ""
+	}
+	rule := policy.rule(data.ConversationId)
+	requireAt := policy.RequireAt
+	if rule.RequireAt != nil {
+		requireAt = *rule.RequireAt
+	}
+	// 需要唤醒词的消息用唤醒词代替@
+	if requireAt && !botMentioned(data) && !wakeWordRequired(data, data.Msgtype) {
+		fmt.Println("消息没有@机器人, 忽略:", data.ConversationId)
+		return false, ""
+	}
+	if rule.restricted() && !r.senderAllowed(data, rule) {
+		fmt.Println("发送者没有权限使用机器人:", data.SenderNick, data.SenderStaffId)
+		return false, "你没有在本群使用机器人的权限"
+	}
+	return true, ""
+}
+
+// 发送者是否满足群规则中的任一条件
+func (r *Robot) senderAllowed(data *chatbot.BotCallbackDataModel, rule groupRule) bool {
+	if rule.Admins && data.IsAdmin {
+		return true
+	}
+	if data.SenderStaffId != "" && selfutils.StringInSlice(data.SenderStaffId, rule.StaffIds) {
+		return true
+	}
+	if len(rule.DeptIds) == 0 {
+		return false
+	}
+	for _, deptId := range r.senderDeptIds(data) {
+		if r.deptInAny(deptId, rule.DeptIds) {
+			return true
+		}
+	}
+	return false
+}
+
+// 部门或其上级部门是否在deptIds中
+func (r *Robot) deptInAny(deptId int64, deptIds []int64) bool {
+	for depth := 0; depth < maxDeptDepth && deptId != 0; depth++ {
+		for _, id := range deptIds {
+			if id == deptId {
+				return true
+			}
+		}
+		// 根部门没有上级部门
+		if deptId == 1 {
+			return false
+		}
+		info, err := r.contacts.GetDeptInfo(deptId)
+		if err != nil {
+			fmt.Println("Error getting dept info:", err)
+			return false
+		}
+		deptId = info.ParentId
+	}
+	return false
+}
+
+// 消息是否@了机器人
+func botMentioned(data *chatbot.BotCallbackDataModel) bool {
+	if data.IsInAtList {
+		return true
+	}
+	for _, user := range data.AtUsers {
+		if user.DingtalkId != "" && user.DingtalkId == data.ChatbotUserId {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉消息开头@机器人的文本
+// 钉钉的@文本为 "@名称 ", 机器人被@时去掉开头的第一个@名称, 不依赖机器人在群里的名称
+func stripAtBot(data *chatbot.BotCallbackDataModel, text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "@") || !botMentioned(data) {
+		return text
+	}
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return ""
+	}
+	return strings.TrimSpace(text[end:])
+}
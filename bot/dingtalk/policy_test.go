@@ -0,0 +1,225 @@
+package dingbot
+
+import (
+	"ding/clients"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"testing"
+)
+
+// 设置群聊策略, 测试结束后恢复
+func setGroupPolicy(t *testing.T, p groupPolicy) {
+	oldPolicy := policy
+	policy = p
+	t.Cleanup(func() {
+		policy = oldPolicy
+	})
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// 部门树: 1 根部门 > 100 研发中心 > 200 平台部 > 300 基础架构组, 1 > 400 销售部
+func testDeptContacts() *fakeContacts {
+	return &fakeContacts{
+		users: map[string]*clients.UserInfo{
+			"infra1": {UserId: "infra1", DeptIdList: []int64{300}},
+			"sales1": {UserId: "sales1", DeptIdList: []int64{400}},
+			"multi1": {UserId: "multi1", DeptIdList: []int64{400, 200}},
+		},
+		depts: map[int64]*clients.DeptInfo{
+			100: {DeptId: 100, ParentId: 1},
+			200: {DeptId: 200, ParentId: 100},
+			300: {DeptId: 300, ParentId: 200},
+			400: {DeptId: 400, ParentId: 1},
+		},
+	}
+}
+
+func TestCheckGroupPolicy(t *testing.T) {
+	setWakeWord(t, nil, wakeWordConfig{scope: wakeWordOff})
+	setGroupPolicy(t, groupPolicy{
+		RequireAt: true,
+		Allow:     []string{"cid-open", "cid-admins", "cid-staff", "cid-dept", "cid-denied"},
+		Deny:      []string{"cid-denied"},
+		Groups: map[string]groupRule{
+			"cid-open":   {RequireAt: boolPtr(false)},
+			"cid-admins": {Admins: true},
+			"cid-staff":  {StaffIds: []string{"manager1"}},
+			"cid-dept":   {DeptIds: []int64{100}, StaffIds: []string{"manager1"}},
+		},
+	})
+	r := &Robot{contacts: testDeptContacts()}
+	for _, tc := range []struct {
+		name           string
+		conversationId string
+		single         bool
+		mentioned      bool
+		staffId        string
+		admin          bool
+		wantAllowed    bool
+		wantReply      bool
+	}{
+		{name: "single chat", single: true, conversationId: "cid-unknown", wantAllowed: true},
+		{name: "not in allow list", conversationId: "cid-unknown", mentioned: true, wantAllowed: false},
+		{name: "denied", conversationId: "cid-denied", mentioned: true, wantAllowed: false},
+		{name: "require at", conversationId: "cid-admins", admin: true, wantAllowed: false},
+		{name: "group overrides require at", conversationId: "cid-open", wantAllowed: true},
+		{name: "admin allowed", conversationId: "cid-admins", mentioned: true, admin: true, wantAllowed: true},
+		{name: "non-admin rejected", conversationId: "cid-admins", mentioned: true, staffId: "manager1", wantReply: true},
+		{name: "staff allowed", conversationId: "cid-staff", mentioned: true, staffId: "manager1", wantAllowed: true},
+		{name: "other staff rejected", conversationId: "cid-staff", mentioned: true, staffId: "infra1", wantReply: true},
+		{name: "sub dept allowed", conversationId: "cid-dept", mentioned: true, staffId: "infra1", wantAllowed: true},
+		{name: "one of depts allowed", conversationId: "cid-dept", mentioned: true, staffId: "multi1", wantAllowed: true},
+		{name: "staff allowed with depts", conversationId: "cid-dept", mentioned: true, staffId: "manager1", wantAllowed: true},
+		{name: "other dept rejected", conversationId: "cid-dept", mentioned: true, staffId: "sales1", wantReply: true},
+		{name: "unknown sender rejected", conversationId: "cid-dept", mentioned: true, staffId: "nobody", wantReply: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &chatbot.BotCallbackDataModel{
+				ConversationType: "2",
+				ConversationId:   tc.conversationId,
+				Msgtype:          "text",
+				IsInAtList:       tc.mentioned,
+				SenderStaffId:    tc.staffId,
+				IsAdmin:          tc.admin,
+			}
+			if tc.single {
+				data.ConversationType = "1"
+			}
+			allowed, res := r.checkGroupPolicy(data)
+			if allowed != tc.wantAllowed || (res != "") != tc.wantReply {
+				t.Errorf("checkGroupPolicy() = %v, %q, want %v, reply %v", allowed, res, tc.wantAllowed, tc.wantReply)
+			}
+		})
+	}
+}
+
+func TestCheckGroupPolicyDefaultRule(t *testing.T) {
+	setWakeWord(t, nil, wakeWordConfig{scope: wakeWordOff})
+	setGroupPolicy(t, groupPolicy{Groups: map[string]groupRule{
+		groupPolicyDefaultKey: {StaffIds: []string{"manager1"}},
+		"cid-free":            {},
+	}})
+	r := &Robot{contacts: testDeptContacts()}
+	for _, tc := range []struct {
+		conversationId string
+		staffId        string
+		want           bool
+	}{
+		{"cid-any", "manager1", true},
+		{"cid-any", "infra1", false},
+		{"cid-free", "infra1", true},
+	} {
+		data := &chatbot.BotCallbackDataModel{ConversationType: "2", ConversationId: tc.conversationId, SenderStaffId: tc.staffId}
+		if allowed, _ := r.checkGroupPolicy(data); allowed != tc.want {
+			t.Errorf("checkGroupPolicy(%s, %s) = %v, want %v", tc.conversationId, tc.staffId, allowed, tc.want)
+		}
+	}
+}
+
+func TestCheckGroupPolicyWakeWordReplacesAt(t *testing.T) {
+	setWakeWord(t, []string{"小钉"}, wakeWordConfig{scope: wakeWordVoice})
+	setGroupPolicy(t, groupPolicy{RequireAt: true})
+	r := &Robot{contacts: testDeptContacts()}
+	voice := &chatbot.BotCallbackDataModel{ConversationType: "2", ConversationId: "cid", Msgtype: "audio"}
+	if allowed, _ := r.checkGroupPolicy(voice); !allowed {
+		t.Errorf("voice message needing a wake word rejected without @")
+	}
+	text := &chatbot.BotCallbackDataModel{ConversationType: "2", ConversationId: "cid", Msgtype: "text"}
+	if allowed, _ := r.checkGroupPolicy(text); allowed {
+		t.Errorf("text message allowed without @")
+	}
+}
+
+func TestDeptInAny(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		deptId      int64
+		deptIds     []int64
+		want        bool
+		wantLookups int
+	}{
+		{name: "same dept", deptId: 300, deptIds: []int64{300}, want: true, wantLookups: 0},
+		{name: "parent", deptId: 300, deptIds: []int64{200}, want: true, wantLookups: 1},
+		{name: "grandparent", deptId: 300, deptIds: []int64{100}, want: true, wantLookups: 2},
+		{name: "root", deptId: 300, deptIds: []int64{1}, want: true, wantLookups: 3},
+		{name: "sibling tree", deptId: 300, deptIds: []int64{400}, want: false, wantLookups: 3},
+		{name: "lookup error", deptId: 999, deptIds: []int64{100}, want: false, wantLookups: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contacts := testDeptContacts()
+			r := &Robot{contacts: contacts}
+			if got := r.deptInAny(tc.deptId, tc.deptIds); got != tc.want {
+				t.Errorf("deptInAny(%d, %v) = %v, want %v", tc.deptId, tc.deptIds, got, tc.want)
+			}
+			if contacts.deptLookups != tc.wantLookups {
+				t.Errorf("dept lookups = %d, want %d", contacts.deptLookups, tc.wantLookups)
+			}
+		})
+	}
+}
+
+func TestDeptInAnyStopsOnCycle(t *testing.T) {
+	contacts := &fakeContacts{depts: map[int64]*clients.DeptInfo{
+		5: {DeptId: 5, ParentId: 6},
+		6: {DeptId: 6, ParentId: 5},
+	}}
+	r := &Robot{contacts: contacts}
+	if r.deptInAny(5, []int64{100}) {
+		t.Errorf("deptInAny with a cycle = true, want false")
+	}
+	if contacts.deptLookups != maxDeptDepth {
+		t.Errorf("dept lookups = %d, want %d", contacts.deptLookups, maxDeptDepth)
+	}
+}
+
+func TestBotMentioned(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data *chatbot.BotCallbackDataModel
+		want bool
+	}{
+		{name: "in at list", data: &chatbot.BotCallbackDataModel{IsInAtList: true}, want: true},
+		{
+			name: "at users",
+			data: &chatbot.BotCallbackDataModel{ChatbotUserId: "bot1", AtUsers: []chatbot.BotCallbackDataAtUserModel{{DingtalkId: "user1"}, {DingtalkId: "bot1"}}},
+			want: true,
+		},
+		{
+			name: "other users",
+			data: &chatbot.BotCallbackDataModel{ChatbotUserId: "bot1", AtUsers: []chatbot.BotCallbackDataAtUserModel{{DingtalkId: "user1"}}},
+			want: false,
+		},
+		{name: "empty ids", data: &chatbot.BotCallbackDataModel{AtUsers: []chatbot.BotCallbackDataAtUserModel{{}}}, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := botMentioned(tc.data); got != tc.want {
+				t.Errorf("botMentioned() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripAtBot(t *testing.T) {
+	mentioned := &chatbot.BotCallbackDataModel{IsInAtList: true}
+	for _, tc := range []struct {
+		name string
+		data *chatbot.BotCallbackDataModel
+		text string
+		want string
+	}{
+		{name: "leading mention", data: mentioned, text: "@小钉 今天天气怎么样", want: "今天天气怎么样"},
+		{name: "renamed bot", data: mentioned, text: " @新名字机器人  查一下 ", want: "查一下"},
+		{name: "mention only", data: mentioned, text: "@小钉", want: ""},
+		{name: "full width space", data: mentioned, text: "@小钉　你好", want: "你好"},
+		{name: "no leading mention", data: mentioned, text: "你好 @小钉", want: "你好 @小钉"},
+		{name: "not mentioned", data: &chatbot.BotCallbackDataModel{}, text: "@张三 你看下", want: "@张三 你看下"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stripAtBot(tc.data, tc.text); got != tc.want {
+				t.Errorf("stripAtBot(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package dingbot
+
+import (
+	"context"
+	"ding/bot/difybot"
+	"ding/consts"
+	selfutils "ding/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/card"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rateLimitKeyPrefix = "ratelimit:"
+	quotaKeyPrefix     = "quota:"
+	// 每日额度按天计数, 多保留一小时避免跨天时提前过期
+	quotaTTL = 25 * time.Hour
+)
+
+// 限流和每日额度, 值为0时不限制
+type rateLimitRule struct {
+	PerMinute     float64 `json:"per_minute"`     // 每分钟的消息数
+	Burst         int     `json:"burst"`          // 连续发送的最大消息数, 默认为 per_minute
+	DailyMessages int64   `json:"daily_messages"` // 每天的消息数
+	DailyTokens   int64   `json:"daily_tokens"`   // 每天的token数
+}
+
+type rateLimitConfig struct {
+	User            rateLimitRule            `json:"user"`              // 每个发送者
+	Group           rateLimitRule            `json:"group"`             // 每个群
+	Groups          map[string]rateLimitRule `json:"groups"`            // 按群的ConversationId覆盖 group
+	Admins          []string                 `json:"admins"`            // 不受限制的员工staffId, 可以重置额度
+	ExemptOrgAdmins bool                     `json:"exempt_org_admins"` // 企业管理员不受限制, 可以重置额度
+}
+
+var rateLimit rateLimitConfig
+
+// 加载 RATE_LIMIT 限流配置, 格式为JSON
+// 例如 {"user":{"per_minute":5,"daily_messages":200,"daily_tokens":100000},"group":{"per_minute":20},"admins":["manager1"]}
+func loadRateLimit() {
+	rateLimit = rateLimitConfig{}
+	limitConfig := os.Getenv("RATE_LIMIT")
+	if limitConfig == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(limitConfig), &rateLimit); err != nil {
+		fmt.Println("Error parsing RATE_LIMIT:", err)
+		rateLimit = rateLimitConfig{}
+	}
+}
+
+func rateLimitStore() difybot.SessionStore {
+	return difybot.DifyClient.Store
+}
+
+// 发送者的限流标识, 优先使用staffId, 方便管理员重置额度
+func senderLimitId(data *chatbot.BotCallbackDataModel) string {
+	if data.SenderStaffId != "" {
+		return data.SenderStaffId
+	}
+	return data.SenderId
+}
+
+func (rule rateLimitRule) burst() int {
+	if rule.Burst > 0 {
+		return rule.Burst
+	}
+	if burst := int(rule.PerMinute); burst > 0 {
+		return burst
+	}
+	return 1
+}
+
+// 群的限流规则, 没有单独配置时使用 group
+func groupLimitRule(conversationId string) rateLimitRule {
+	if rule, ok := rateLimit.Groups[conversationId]; ok {
+		return rule
+	}
+	return rateLimit.Group
+}
+
+// 不受限流和额度限制的发送者
+func rateLimitExempt(data *chatbot.BotCallbackDataModel) bool {
+	if rateLimit.ExemptOrgAdmins && data.IsAdmin {
+		return true
+	}
+	return data.SenderStaffId != "" && selfutils.StringInSlice(data.SenderStaffId, rateLimit.Admins)
+}
+
+// 一个限流对象, 发送者或群
+type limitTarget struct {
+	scope string // user / group
+	id    string
+	rule  rateLimitRule
+	name  string // 提示中的名称
+}
+
+func limitTargets(data *chatbot.BotCallbackDataModel) []limitTarget {
+	targets := []limitTarget{{scope: "user", id: senderLimitId(data), rule: rateLimit.User, name: "你"}}
+	if data.ConversationType == "2" {
+		targets = append(targets, limitTarget{scope: "group", id: data.ConversationId, rule: groupLimitRule(data.ConversationId), name: "本群"})
+	}
+	return targets
+}
+
+func (t limitTarget) bucketKey() string {
+	return rateLimitKeyPrefix + t.scope + ":" + t.id
+}
+
+func (t limitTarget) quotaKey(kind string) string {
+	return quotaKeyPrefix + kind + ":" + t.scope + ":" + t.id + ":" + time.Now().Format("20060102")
+}
+
+// 今天已使用的额度
+func (t limitTarget) used(kind string) int64 {
+	value, ok := rateLimitStore().Get(t.quotaKey(kind))
+	if !ok {
+		return 0
+	}
+	used, _ := strconv.ParseInt(value, 10, 64)
+	return used
+}
+
+// 检查限流和每日额度, 通过时计入今天的消息数, 超过限制时返回回复给用户的提示
+// 存储出错时不限制
+func checkRateLimit(data *chatbot.BotCallbackDataModel) string {
+	if rateLimitExempt(data) {
+		return ""
+	}
+	targets := limitTargets(data)
+	for _, t := range targets {
+		if t.rule.DailyTokens > 0 && t.used("tokens") >= t.rule.DailyTokens {
+			return fmt.Sprintf("%s今天的token额度（%d）已用完，请明天再试", t.name, t.rule.DailyTokens)
+		}
+		if t.rule.DailyMessages > 0 && t.used("messages") >= t.rule.DailyMessages {
+			return fmt.Sprintf("%s今天的消息额度（%d条）已用完，请明天再试", t.name, t.rule.DailyMessages)
+		}
+	}
+	// 所有令牌桶都有令牌时才取走, 避免群被限流时仍然消耗发送者的令牌
+	var buckets []difybot.TokenBucket
+	var bucketTargets []limitTarget
+	for _, t := range targets {
+		if t.rule.PerMinute <= 0 {
+			continue
+		}
+		buckets = append(buckets, difybot.TokenBucket{Key: t.bucketKey(), Rate: t.rule.PerMinute / 60, Burst: t.rule.burst()})
+		bucketTargets = append(bucketTargets, t)
+	}
+	if len(buckets) > 0 {
+		denied, err := rateLimitStore().TakeTokens(buckets)
+		if err != nil {
+			fmt.Println("Error checking rate limit:", err)
+		} else if denied >= 0 {
+			t := bucketTargets[denied]
+			return fmt.Sprintf("%s发送消息太频繁了（每分钟最多%g条），请稍后再试", t.name, t.rule.PerMinute)
+		}
+	}
+	for _, t := range targets {
+		if t.rule.DailyMessages <= 0 {
+			continue
+		}
+		if _, err := rateLimitStore().IncrBy(t.quotaKey("messages"), 1, quotaTTL); err != nil {
+			fmt.Println("Error counting daily messages:", err)
+		}
+	}
+	return ""
+}
+
+// 记录dify回答使用的token数
+func recordTokenUsage(data *chatbot.BotCallbackDataModel, tokens int64) {
+	if tokens <= 0 || rateLimitExempt(data) {
+		return
+	}
+	for _, t := range limitTargets(data) {
+		if t.rule.DailyTokens <= 0 {
+			continue
+		}
+		if _, err := rateLimitStore().IncrBy(t.quotaKey("tokens"), tokens, quotaTTL); err != nil {
+			fmt.Println("Error counting daily tokens:", err)
+		}
+	}
+}
+
+// 卡片按钮的点击者, 群聊中点击的人可能不是提问的人, 限流按点击者计算
+func (r *Robot) cardClicker(request *card.CardRequest, data *chatbot.BotCallbackDataModel) *chatbot.BotCallbackDataModel {
+	clicker := *data
+	if request.UserId == "" || request.UserId == data.SenderStaffId {
+		return &clicker
+	}
+	clicker.SenderId = request.UserId
+	clicker.SenderStaffId = request.UserId
+	clicker.SenderNick = ""
+	clicker.IsAdmin = false
+	if rateLimit.ExemptOrgAdmins {
+		info, err := r.contacts.GetUserInfo(request.UserId)
+		if err != nil {
+			fmt.Println("Error getting user info:", err)
+		} else {
+			clicker.IsAdmin = info.Admin
+		}
+	}
+	return &clicker
+}
+
+// 回复超过限制的提示, 流式输出时使用卡片
+func (r *Robot) replyRateLimited(ctx context.Context, data *chatbot.BotCallbackDataModel, res string) error {
+	fmt.Println("超过限流:", data.SenderNick, data.ConversationId, res)
+	replier := chatbot.NewChatbotReplier()
+	switch r.OutputType {
+	case consts.OutputTypeStream:
+		u, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+		msg := &DingMessage{Robot: r, Data: data, IsGroup: data.ConversationType == "2"}
+		r.sendInteractiveCard(u.String(), msg, buildCardData(false, "⏳ "+res))
+		return nil
+	case consts.OutputTypeMarkDown:
+		return replier.SimpleReplyMarkdown(ctx, data.SessionWebhook, []byte("提示"), []byte(res))
+	}
+	return replier.SimpleReplyText(ctx, data.SessionWebhook, []byte(res))
+}
+
+func quotaCommand(ctx context.Context, data *chatbot.BotCallbackDataModel, args string) (string, error) {
+	if action, target, _ := strings.Cut(args, " "); action == "reset" {
+		return resetQuota(data, strings.TrimSpace(target)), nil
+	}
+	if rateLimitExempt(data) {
+		return "你不受限流和额度限制", nil
+	}
+	var builder strings.Builder
+	for _, t := range limitTargets(data) {
+		builder.WriteString(t.usage())
+	}
+	if builder.Len() == 0 {
+		return "没有配置限流和额度", nil
+	}
+	return builder.String(), nil
+}
+
+// 今天的额度使用情况
+func (t limitTarget) usage() string {
+	var lines []string
+	if t.rule.PerMinute > 0 {
+		lines = append(lines, fmt.Sprintf("- 每分钟最多 %g 条消息", t.rule.PerMinute))
+	}
+	if t.rule.DailyMessages > 0 {
+		lines = append(lines, fmt.Sprintf("- 今日消息: %d / %d", t.used("messages"), t.rule.DailyMessages))
+	}
+	if t.rule.DailyTokens > 0 {
+		lines = append(lines, fmt.Sprintf("- 今日token: %d / %d", t.used("tokens"), t.rule.DailyTokens))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	title := "我的额度"
+	if t.scope == "group" {
+		title = "本群额度"
+	}
+	return fmt.Sprintf("**%s**\n\n%s\n\n", title, strings.Join(lines, "\n"))
+}
+
+// 管理员重置发送者的限流和今天的额度, target为staffId, 为空时重置当前群
+func resetQuota(data *chatbot.BotCallbackDataModel, target string) string {
+	if !rateLimitExempt(data) {
+		return "只有管理员可以重置额度"
+	}
+	t := limitTarget{scope: "user", id: target}
+	if target == "" {
+		if data.ConversationType != "2" {
+			return "请指定需要重置的staffId，例如 /quota reset manager1"
+		}
+		t = limitTarget{scope: "group", id: data.ConversationId}
+	}
+	for _, key := range []string{t.bucketKey(), t.quotaKey("messages"), t.quotaKey("tokens")} {
+		if err := rateLimitStore().Delete(key); err != nil {
+			fmt.Println("Error resetting quota:", err)
+			return "重置失败: " + err.Error()
+		}
+	}
+	if t.scope == "group" {
+		return "已重置本群的额度"
+	}
+	return "已重置 " + target + " 的额度"
+}
@@ -0,0 +1,232 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"ding/consts"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"strings"
+	"testing"
+)
+
+// 设置限流配置和内存存储, 测试结束后恢复
+func setRateLimit(t *testing.T, config rateLimitConfig) difybot.SessionStore {
+	oldConfig, oldStore := rateLimit, difybot.DifyClient.Store
+	store := difybot.NewMemorySessionStore()
+	rateLimit, difybot.DifyClient.Store = config, store
+	t.Cleanup(func() {
+		store.Close()
+		rateLimit, difybot.DifyClient.Store = oldConfig, oldStore
+	})
+	return store
+}
+
+func userMessage(staffId string) *chatbot.BotCallbackDataModel {
+	return &chatbot.BotCallbackDataModel{ConversationType: "1", ConversationId: "cid-" + staffId, SenderId: "sender-" + staffId, SenderStaffId: staffId}
+}
+
+func groupMessage(conversationId, staffId string) *chatbot.BotCallbackDataModel {
+	return &chatbot.BotCallbackDataModel{ConversationType: "2", ConversationId: conversationId, SenderId: "sender-" + staffId, SenderStaffId: staffId}
+}
+
+// 连续检查n次, 返回每次的提示
+func checkTimes(data *chatbot.BotCallbackDataModel, n int) []string {
+	results := make([]string, n)
+	for i := range results {
+		results[i] = checkRateLimit(data)
+	}
+	return results
+}
+
+func TestCheckRateLimitDisabled(t *testing.T) {
+	setRateLimit(t, rateLimitConfig{})
+	for i, res := range checkTimes(groupMessage("cid", "user1"), 100) {
+		if res != "" {
+			t.Fatalf("check #%d = %q, want allowed", i, res)
+		}
+	}
+	target := limitTarget{scope: "user", id: "user1"}
+	if used := target.used("messages"); used != 0 {
+		t.Errorf("messages used = %d, want 0 without daily limit", used)
+	}
+}
+
+func TestCheckRateLimitPerMinute(t *testing.T) {
+	setRateLimit(t, rateLimitConfig{
+		User:   rateLimitRule{PerMinute: 2},
+		Group:  rateLimitRule{PerMinute: 3},
+		Groups: map[string]rateLimitRule{"cid-busy": {PerMinute: 10}},
+	})
+	results := checkTimes(userMessage("user1"), 3)
+	if results[0] != "" || results[1] != "" || !strings.HasPrefix(results[2], "你发送消息太频繁了") {
+		t.Errorf("user checks = %q, want two allowed then limited", results)
+	}
+	// 发送者之间互不影响
+	if res := checkRateLimit(userMessage("user2")); res != "" {
+		t.Errorf("other user = %q, want allowed", res)
+	}
+	// 群的令牌桶由群成员共享
+	checkTimes(groupMessage("cid", "user3"), 2)
+	if res := checkRateLimit(groupMessage("cid", "user4")); res != "" {
+		t.Errorf("third group message = %q, want allowed", res)
+	}
+	if res := checkRateLimit(groupMessage("cid", "user5")); !strings.HasPrefix(res, "本群发送消息太频繁了") {
+		t.Errorf("fourth group message = %q, want group limited", res)
+	}
+	// 单独配置的群使用自己的规则
+	for i, user := range []string{"a", "b", "c", "d", "e"} {
+		if res := checkRateLimit(groupMessage("cid-busy", user)); res != "" {
+			t.Errorf("busy group message #%d = %q, want allowed", i, res)
+		}
+	}
+}
+
+func TestCheckRateLimitDailyMessages(t *testing.T) {
+	setRateLimit(t, rateLimitConfig{
+		User:  rateLimitRule{DailyMessages: 2},
+		Group: rateLimitRule{DailyMessages: 3},
+	})
+	results := checkTimes(userMessage("user1"), 3)
+	if results[0] != "" || results[1] != "" || results[2] != "你今天的消息额度（2条）已用完，请明天再试" {
+		t.Errorf("user checks = %q, want two allowed then quota used up", results)
+	}
+	// 超过额度的消息不计数
+	if used := (limitTarget{scope: "user", id: "user1"}).used("messages"); used != 2 {
+		t.Errorf("user messages used = %d, want 2", used)
+	}
+	for i, user := range []string{"a", "b", "c"} {
+		if res := checkRateLimit(groupMessage("cid", user)); res != "" {
+			t.Errorf("group message #%d = %q, want allowed", i, res)
+		}
+	}
+	if res := checkRateLimit(groupMessage("cid", "d")); res != "本群今天的消息额度（3条）已用完，请明天再试" {
+		t.Errorf("group message after quota = %q, want group quota used up", res)
+	}
+}
+
+func TestCheckRateLimitDailyTokens(t *testing.T) {
+	setRateLimit(t, rateLimitConfig{
+		User:  rateLimitRule{DailyTokens: 1000},
+		Group: rateLimitRule{DailyTokens: 1500},
+	})
+	data := groupMessage("cid", "user1")
+	recordTokenUsage(data, 999)
+	if res := checkRateLimit(data); res != "" {
+		t.Errorf("check under token quota = %q, want allowed", res)
+	}
+	recordTokenUsage(data, 1)
+	if res := checkRateLimit(data); res != "你今天的token额度（1000）已用完，请明天再试" {
+		t.Errorf("check after token quota = %q, want user token quota used up", res)
+	}
+	// 群的token额度包括所有成员的用量
+	other := groupMessage("cid", "user2")
+	recordTokenUsage(other, 500)
+	if res := checkRateLimit(other); res != "本群今天的token额度（1500）已用完，请明天再试" {
+		t.Errorf("check after group token quota = %q, want group token quota used up", res)
+	}
+	if used := (limitTarget{scope: "user", id: "user2"}).used("tokens"); used != 500 {
+		t.Errorf("user2 tokens used = %d, want 500", used)
+	}
+}
+
+func TestCheckRateLimitExempt(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		exemptOrgAdmins bool
+		staffId         string
+		admin           bool
+		want            bool
+	}{
+		{name: "admins list", staffId: "manager1", want: true},
+		{name: "org admin exempt", exemptOrgAdmins: true, staffId: "boss1", admin: true, want: true},
+		{name: "org admin not exempt", staffId: "boss1", admin: true, want: false},
+		{name: "normal user", exemptOrgAdmins: true, staffId: "user1", want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setRateLimit(t, rateLimitConfig{
+				User:            rateLimitRule{PerMinute: 1, DailyMessages: 1, DailyTokens: 10},
+				Admins:          []string{"manager1"},
+				ExemptOrgAdmins: tc.exemptOrgAdmins,
+			})
+			data := userMessage(tc.staffId)
+			data.IsAdmin = tc.admin
+			if got := rateLimitExempt(data); got != tc.want {
+				t.Fatalf("rateLimitExempt() = %v, want %v", got, tc.want)
+			}
+			recordTokenUsage(data, 100)
+			results := checkTimes(data, 3)
+			limited := results[0] != "" || results[1] != "" || results[2] != ""
+			if limited == tc.want {
+				t.Errorf("checks = %q, exempt %v", results, tc.want)
+			}
+			// 不受限制的发送者不计数
+			target := limitTarget{scope: "user", id: tc.staffId}
+			if tc.want && (target.used("messages") != 0 || target.used("tokens") != 0) {
+				t.Errorf("exempt sender counted: messages %d, tokens %d", target.used("messages"), target.used("tokens"))
+			}
+		})
+	}
+}
+
+func TestResetQuota(t *testing.T) {
+	setRateLimit(t, rateLimitConfig{
+		User:   rateLimitRule{DailyMessages: 1},
+		Group:  rateLimitRule{DailyMessages: 1},
+		Admins: []string{"manager1"},
+	})
+	checkRateLimit(groupMessage("cid", "user1"))
+	if res := checkRateLimit(userMessage("user1")); res == "" {
+		t.Fatalf("second message allowed, want quota used up")
+	}
+	if res := resetQuota(userMessage("user1"), "user1"); res != "只有管理员可以重置额度" {
+		t.Errorf("reset by user = %q, want rejected", res)
+	}
+	if res := resetQuota(userMessage("manager1"), "user1"); res != "已重置 user1 的额度" {
+		t.Errorf("reset by admin = %q", res)
+	}
+	if res := checkRateLimit(userMessage("user1")); res != "" {
+		t.Errorf("message after user reset = %q, want allowed", res)
+	}
+	if res := checkRateLimit(groupMessage("cid", "user2")); res == "" {
+		t.Errorf("group message allowed, want group quota used up")
+	}
+	if res := resetQuota(groupMessage("cid", "manager1"), ""); res != "已重置本群的额度" {
+		t.Errorf("group reset by admin = %q", res)
+	}
+	if res := checkRateLimit(groupMessage("cid", "user2")); res != "" {
+		t.Errorf("group message after reset = %q, want allowed", res)
+	}
+}
+
+func TestVoiceWithoutWakeWordKeepsQuota(t *testing.T) {
+	store := setRateLimit(t, rateLimitConfig{
+		User:  rateLimitRule{PerMinute: 1, DailyMessages: 5},
+		Group: rateLimitRule{PerMinute: 1, DailyMessages: 5},
+	})
+	setWakeWord(t, []string{"小钉"}, wakeWordConfig{scope: wakeWordVoice})
+	r := &Robot{DifyApp: "default", contacts: &fakeContacts{}}
+	voice := func(text string) *DingMessage {
+		data := groupMessage("cid", "user1")
+		data.Msgtype = consts.ReceivedTypeVoice
+		return &DingMessage{Robot: r, Data: data, MsgType: data.Msgtype, ReceivedMsgStr: text, WakeWord: true}
+	}
+	for i := 0; i < 3; i++ {
+		if voice("今天天气怎么样").acceptRecognizedVoice() {
+			t.Fatalf("voice without wake word accepted")
+		}
+	}
+	user, group := limitTarget{scope: "user", id: "user1"}, limitTarget{scope: "group", id: "cid"}
+	if user.used("messages") != 0 || group.used("messages") != 0 {
+		t.Errorf("messages used = %d, %d, want 0", user.used("messages"), group.used("messages"))
+	}
+	for _, key := range []string{user.bucketKey(), group.bucketKey()} {
+		if _, ok := store.Get(key); ok {
+			t.Errorf("token bucket %s created by voice without wake word", key)
+		}
+	}
+	if !voice("小钉，今天天气怎么样").acceptRecognizedVoice() {
+		t.Fatalf("voice with wake word rejected")
+	}
+	if user.used("messages") != 1 || group.used("messages") != 1 {
+		t.Errorf("messages used = %d, %d, want 1", user.used("messages"), group.used("messages"))
+	}
+}
@@ -0,0 +1,190 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"ding/clients"
+	"ding/consts"
+	selfutils "ding/utils"
+	audio "ding/voices"
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/dingtalk/robot_1_0"
+	"github.com/alibabacloud-go/tea/tea"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dify生成的文件的回复方式
+const (
+	// 下载后上传到钉钉, 作为图片或文件消息发送
+	fileReplyMessage = "message"
+	// 在卡片中展示图片或链接
+	fileReplyMarkdown = "markdown"
+)
+
+// 语音回复的方式
+const (
+	// 用户发送语音时回复语音
+	voiceReplyVoice = "voice"
+	// 所有消息都回复语音
+	voiceReplyAlways = "always"
+)
+
+const (
+	// 钉钉语音消息的最大时长
+	maxVoiceSeconds = 60
+)
+
+var (
+	// 钉钉图片消息支持的图片格式
+	dingImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp"}
+)
+
+// 以机器人身份发送消息, 群聊发到群里, 单聊发给发送者
+// msgKey和msgParam见钉钉机器人消息类型, 例如 sampleImageMsg / sampleFile
+func (r *Robot) sendRobotMessage(msg *DingMessage, msgKey string, msgParam map[string]string) error {
+	msgParamBytes, err := json.Marshal(msgParam)
+	if err != nil {
+		return err
+	}
+	if msg.IsGroup {
+		_, err = r.Client.OrgGroupSend(&robot_1_0.OrgGroupSendRequest{
+			MsgKey:             tea.String(msgKey),
+			MsgParam:           tea.String(string(msgParamBytes)),
+			OpenConversationId: tea.String(msg.Data.ConversationId),
+		})
+		return err
+	}
+	_, err = r.Client.BatchSendOTO(&robot_1_0.BatchSendOTORequest{
+		MsgKey:   tea.String(msgKey),
+		MsgParam: tea.String(string(msgParamBytes)),
+		UserIds:  []*string{tea.String(msg.Data.SenderStaffId)},
+	})
+	return err
+}
+
+// 回复dify生成的文件, 返回需要追加到卡片中的markdown
+// DIFY_FILE_REPLY=markdown 时全部在卡片中展示, 否则作为消息发送, 发送失败或超过大小限制时在卡片中展示链接
+func (msg *DingMessage) replyFiles(files []difybot.MessageFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	for _, file := range files {
+		if os.Getenv("DIFY_FILE_REPLY") != fileReplyMarkdown {
+			err := msg.sendFile(file)
+			if err == nil {
+				continue
+			}
+			fmt.Println("Error sending dify file:", err)
+		}
+		builder.WriteString("\n\n" + fileMarkdown(file))
+	}
+	return builder.String()
+}
+
+// 下载dify生成的文件, 上传到钉钉后发送
+func (msg *DingMessage) sendFile(file difybot.MessageFile) error {
+	data, err := selfutils.DownloadFile(file.URL)
+	if err != nil {
+		return err
+	}
+	fileName := fileNameFromURL(file.URL)
+	ext := strings.ToLower(path.Ext(fileName))
+	if file.Type == difybot.FileTypeImage && selfutils.StringInSlice(ext, dingImageExtensions) {
+		mediaId, err := msg.Robot.Client.UploadMedia(clients.MediaTypeImage, fileName, data)
+		if err != nil {
+			return err
+		}
+		return msg.Robot.sendRobotMessage(msg, "sampleImageMsg", map[string]string{"photoURL": mediaId})
+	}
+	mediaId, err := msg.Robot.Client.UploadMedia(clients.MediaTypeFile, fileName, data)
+	if err != nil {
+		return err
+	}
+	return msg.Robot.sendRobotMessage(msg, "sampleFile", map[string]string{
+		"mediaId":  mediaId,
+		"fileName": fileName,
+		"fileType": strings.TrimPrefix(ext, "."),
+	})
+}
+
+// 文件在卡片中的展示, 图片直接展示, 其他文件展示为链接
+func fileMarkdown(file difybot.MessageFile) string {
+	fileName := fileNameFromURL(file.URL)
+	if file.Type == difybot.FileTypeImage {
+		return fmt.Sprintf("![%s](%s)", fileName, file.URL)
+	}
+	return fmt.Sprintf("[%s](%s)", fileName, file.URL)
+}
+
+// 从文件地址中取文件名, 取不到时使用默认名称
+func fileNameFromURL(fileUrl string) string {
+	u, err := url.Parse(fileUrl)
+	if err == nil {
+		if name := path.Base(u.Path); name != "" && name != "/" && name != "." {
+			return name
+		}
+	}
+	return "file"
+}
+
+// 是否需要回复语音, VOICE_REPLY=voice 时只回复语音消息, always 时回复所有消息
+func (msg *DingMessage) voiceReplyEnabled() bool {
+	switch os.Getenv("VOICE_REPLY") {
+	case voiceReplyAlways:
+		return true
+	case voiceReplyVoice:
+		return msg.MsgType == consts.ReceivedTypeVoice
+	}
+	return false
+}
+
+// 将回答转为语音消息发送
+// 优先使用流式响应中的tts_message语音, 没有时调用dify的文字转语音
+// 有ffmpeg时转码为amr, 超过60秒的部分会被截断, 没有ffmpeg时直接发送dify返回的音频
+func (msg *DingMessage) replyVoice(userID string, result *difybot.StreamResult) error {
+	app := difybot.App(msg.AppName)
+	data := result.Audio.Bytes()
+	if len(data) == 0 {
+		if !app.TextToSpeechEnabled() {
+			return fmt.Errorf("dify app %s text to speech is disabled", app.Name)
+		}
+		var err error
+		data, err = app.TextToAudio(result.Answer.String(), userID, result.MessageID)
+		if err != nil {
+			return err
+		}
+	}
+	if audio.FFmpegPath() != "" {
+		amr, err := audio.ToAMR(data, maxVoiceSeconds)
+		if err != nil {
+			fmt.Println("Error transcoding voice:", err)
+		} else {
+			data = amr
+		}
+	}
+	format := audio.DetectFormat(data)
+	if format == audio.FormatUnknown {
+		return fmt.Errorf("unsupported voice format")
+	}
+	duration, err := audio.Duration(data)
+	if err != nil {
+		return err
+	}
+	if duration > maxVoiceSeconds*time.Second {
+		return fmt.Errorf("voice duration %s exceeds %ds", duration, maxVoiceSeconds)
+	}
+	mediaId, err := msg.Robot.Client.UploadMedia(clients.MediaTypeVoice, "voice."+format, data)
+	if err != nil {
+		return err
+	}
+	return msg.Robot.sendRobotMessage(msg, "sampleAudio", map[string]string{
+		"mediaId":  mediaId,
+		"duration": strconv.FormatInt(duration.Milliseconds(), 10),
+	})
+}
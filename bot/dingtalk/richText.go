@@ -0,0 +1,59 @@
+package dingbot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 富文本消息中的一段内容, 文字和图片按原始顺序排列
+type richTextSegment struct {
+	Text         string
+	DownloadCode string
+}
+
+// 解析钉钉富文本消息
+// content 格式: {"richText":[{"text":"..."},{"type":"picture","downloadCode":"..."}]}
+func parseRichText(content interface{}) []richTextSegment {
+	segments := []richTextSegment{}
+	contentMap, ok := content.(map[string]interface{})
+	if !ok {
+		return segments
+	}
+	items, ok := contentMap["richText"].([]interface{})
+	if !ok {
+		return segments
+	}
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if text, ok := itemMap["text"].(string); ok && text != "" {
+			segments = append(segments, richTextSegment{Text: text})
+			continue
+		}
+		downloadCode, _ := itemMap["downloadCode"].(string)
+		if downloadCode == "" {
+			downloadCode, _ = itemMap["pictureDownloadCode"].(string)
+		}
+		if downloadCode != "" {
+			segments = append(segments, richTextSegment{DownloadCode: downloadCode})
+		}
+	}
+	return segments
+}
+
+// 将富文本拼接为发给dify的问题, 图片位置用 [图片N] 标记
+func buildRichTextQuery(segments []richTextSegment) string {
+	var builder strings.Builder
+	imageIndex := 0
+	for _, segment := range segments {
+		if segment.DownloadCode != "" {
+			imageIndex++
+			builder.WriteString(fmt.Sprintf("[图片%d]", imageIndex))
+			continue
+		}
+		builder.WriteString(segment.Text)
+	}
+	return strings.TrimSpace(builder.String())
+}
@@ -0,0 +1,101 @@
+package dingbot
+
+import (
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"reflect"
+	"testing"
+)
+
+func TestParseRichText(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content interface{}
+		want    []richTextSegment
+	}{
+		{
+			name: "mixed text and pictures",
+			content: map[string]interface{}{"richText": []interface{}{
+				map[string]interface{}{"text": "这两张图"},
+				map[string]interface{}{"type": "picture", "downloadCode": "code1"},
+				map[string]interface{}{"text": "有什么区别"},
+				map[string]interface{}{"type": "picture", "pictureDownloadCode": "code2"},
+			}},
+			want: []richTextSegment{{Text: "这两张图"}, {DownloadCode: "code1"}, {Text: "有什么区别"}, {DownloadCode: "code2"}},
+		},
+		{
+			name: "pictures only",
+			content: map[string]interface{}{"richText": []interface{}{
+				map[string]interface{}{"type": "picture", "downloadCode": "code1"},
+				map[string]interface{}{"type": "picture", "downloadCode": "code2"},
+			}},
+			want: []richTextSegment{{DownloadCode: "code1"}, {DownloadCode: "code2"}},
+		},
+		{
+			name: "empty and unknown items skipped",
+			content: map[string]interface{}{"richText": []interface{}{
+				map[string]interface{}{"text": ""},
+				map[string]interface{}{"type": "picture"},
+				"invalid",
+				map[string]interface{}{"text": "你好"},
+			}},
+			want: []richTextSegment{{Text: "你好"}},
+		},
+		{name: "missing richText", content: map[string]interface{}{}, want: []richTextSegment{}},
+		{name: "not a map", content: "text", want: []richTextSegment{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseRichText(tc.content); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseRichText() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildRichTextQuery(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		segments []richTextSegment
+		want     string
+	}{
+		{
+			name:     "mixed text and pictures",
+			segments: []richTextSegment{{Text: "这两张图"}, {DownloadCode: "code1"}, {Text: "和"}, {DownloadCode: "code2"}, {Text: "有什么区别 "}},
+			want:     "这两张图[图片1]和[图片2]有什么区别",
+		},
+		{
+			name:     "pictures only",
+			segments: []richTextSegment{{DownloadCode: "code1"}, {DownloadCode: "code2"}},
+			want:     "[图片1][图片2]",
+		},
+		{name: "empty", segments: []richTextSegment{}, want: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := buildRichTextQuery(tc.segments); got != tc.want {
+				t.Errorf("buildRichTextQuery() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// 群里@机器人的富文本消息, @文本在第一段文字中
+func TestRichTextMention(t *testing.T) {
+	content := map[string]interface{}{"richText": []interface{}{
+		map[string]interface{}{"text": "@小钉 看下这张图"},
+		map[string]interface{}{"type": "picture", "downloadCode": "code1"},
+		map[string]interface{}{"text": "是什么"},
+	}}
+	for _, tc := range []struct {
+		name string
+		data *chatbot.BotCallbackDataModel
+		want string
+	}{
+		{name: "mentioned", data: &chatbot.BotCallbackDataModel{IsInAtList: true}, want: "看下这张图[图片1]是什么"},
+		{name: "not mentioned", data: &chatbot.BotCallbackDataModel{}, want: "@小钉 看下这张图[图片1]是什么"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stripAtBot(tc.data, buildRichTextQuery(parseRichText(content))); got != tc.want {
+				t.Errorf("query = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
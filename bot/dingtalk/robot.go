@@ -0,0 +1,150 @@
+package dingbot
+
+import (
+	"context"
+	"ding/bot/difybot"
+	"ding/clients"
+	"ding/consts"
+	"encoding/json"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/client"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/utils"
+	"os"
+	"sync"
+)
+
+const (
+	// 每个机器人的消息队列容量和消费者数量
+	robotQueueSize    = 1000
+	robotNumConsumers = 5
+)
+
+// 一个钉钉机器人, 每个机器人有独立的stream连接, 消息队列和access token
+type Robot struct {
+	Name         string `json:"name"`
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	OutputType   string `json:"output_type"`
+	DifyApp      string `json:"dify_app"` // 未命中路由规则时使用的dify应用
+
+	Client       *clients.DingTalkClient
+	contacts     contactDirectory // 查询通讯录, 默认为Client
+	messageQueue chan *DingMessage
+	wg           sync.WaitGroup
+	streamClient *client.StreamClient
+}
+
+// 通讯录查询, 用于路由、群聊策略和输入变量
+type contactDirectory interface {
+	GetUserInfo(userId string) (*clients.UserInfo, error)
+	GetDeptInfo(deptId int64) (*clients.DeptInfo, error)
+}
+
+type robotContextKey struct{}
+
+// 从指令等回调的context中获取当前机器人
+func RobotFromContext(ctx context.Context) *Robot {
+	r, _ := ctx.Value(robotContextKey{}).(*Robot)
+	return r
+}
+
+// 加载机器人配置
+// DING_ROBOTS 为JSON数组, 例如 [{"name":"hr","client_id":"...","client_secret":"...","output_type":"Stream","dify_app":"hr"}]
+// 未配置时使用 CLIENT_ID / CLIENT_SECRET / Output_Type 作为唯一的机器人
+func loadRobots() []*Robot {
+	robots := []*Robot{}
+	if robotsConfig := os.Getenv("DING_ROBOTS"); robotsConfig != "" {
+		if err := json.Unmarshal([]byte(robotsConfig), &robots); err != nil {
+			fmt.Println("Error parsing DING_ROBOTS:", err)
+			robots = []*Robot{}
+		}
+	}
+	if len(robots) == 0 {
+		robots = append(robots, &Robot{
+			Name:         "default",
+			ClientId:     os.Getenv("CLIENT_ID"),
+			ClientSecret: os.Getenv("CLIENT_SECRET"),
+			OutputType:   os.Getenv("Output_Type"),
+			DifyApp:      difybot.DefaultAppName,
+		})
+	}
+	for i, r := range robots {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("robot%d", i+1)
+		}
+		if r.OutputType == "" {
+			r.OutputType = consts.OutputTypeStream
+		}
+		if r.DifyApp == "" {
+			r.DifyApp = difybot.DefaultAppName
+		} else if !difybot.HasApp(r.DifyApp) {
+			fmt.Printf("机器人 %s 绑定的dify应用 %s 不存在, 使用默认应用\n", r.Name, r.DifyApp)
+			r.DifyApp = difybot.DefaultAppName
+		}
+	}
+	return robots
+}
+
+// 启动机器人的stream连接和消息消费者
+func (r *Robot) start() error {
+	r.Client = clients.NewDingTalkClient(r.ClientId, r.ClientSecret)
+	r.contacts = r.Client
+	r.messageQueue = make(chan *DingMessage, robotQueueSize) // 设置队列容量
+	// 启动多个消费者
+	for i := 0; i < robotNumConsumers; i++ {
+		r.wg.Add(1)
+		go r.messageConsumer()
+	}
+
+	topic := os.Getenv("Ding_Topic")
+	cli := &client.StreamClient{}
+	if r.OutputType == consts.OutputTypeText {
+		//纯文本或markdown输出
+		cli = client.NewStreamClient(
+			client.WithAppCredential(client.NewAppCredentialConfig(r.ClientId, r.ClientSecret)),
+			client.WithUserAgent(client.NewDingtalkGoSDKUserAgent()),
+			client.WithSubscription(utils.SubscriptionTypeKCallback, topic, chatbot.NewDefaultChatBotFrameHandler(r.OnChatReceiveText).OnEventReceived),
+		)
+	} else if r.OutputType == consts.OutputTypeStream {
+		// 流式输出
+		cli = client.NewStreamClient(
+			client.WithAppCredential(client.NewAppCredentialConfig(r.ClientId, r.ClientSecret)))
+		cli.RegisterChatBotCallbackRouter(r.OnChatBotStreamingMessageReceived)
+		// 卡片按钮回调
+		cli.RegisterCardCallbackRouter(r.OnCardCallback)
+	} else if r.OutputType == consts.OutputTypeMarkDown {
+		cli = client.NewStreamClient(
+			client.WithAppCredential(client.NewAppCredentialConfig(r.ClientId, r.ClientSecret)))
+		cli.RegisterChatBotCallbackRouter(r.OnChatReceiveMarkDown)
+	} else {
+		return fmt.Errorf("robot %s: unsupported output type %s", r.Name, r.OutputType)
+	}
+	r.streamClient = cli
+	fmt.Printf("启动钉钉机器人 %s, 输出模式 %s, dify应用 %s\n", r.Name, r.OutputType, r.DifyApp)
+	return cli.Start(context.Background())
+}
+
+// 关闭stream连接和消息队列
+func (r *Robot) stop() {
+	if r.streamClient != nil {
+		r.streamClient.Close()
+	}
+	if r.messageQueue != nil {
+		close(r.messageQueue)
+	}
+}
+
+func (r *Robot) messageConsumer() {
+	defer r.wg.Done()
+	for msg := range r.messageQueue {
+		// 处理消息的逻辑
+		msg.processMessage()
+	}
+}
+
+// 将消息放入机器人的队列
+func (r *Robot) enqueue(msg *DingMessage) {
+	msg.Robot = r
+	r.messageQueue <- msg
+}
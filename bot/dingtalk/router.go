@@ -0,0 +1,100 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"encoding/json"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 消息路由规则, 按顺序匹配, 命中后由对应的dify应用处理
+type appRoute struct {
+	App            string `json:"app"`
+	Keyword        string `json:"keyword"`         // 消息开头的关键词, 例如 #hr
+	ConversationId string `json:"conversation_id"` // 群的ConversationId
+	DeptId         int64  `json:"dept_id"`         // 发送者所在部门
+}
+
+var appRoutes []appRoute
+
+// 加载 DIFY_ROUTES 路由表, 格式为JSON数组
+// 例如 [{"app":"hr","keyword":"#hr"},{"app":"it","conversation_id":"cid..."},{"app":"code","dept_id":123}]
+func loadAppRoutes() {
+	appRoutes = nil
+	routesConfig := os.Getenv("DIFY_ROUTES")
+	if routesConfig == "" {
+		return
+	}
+	var routes []appRoute
+	if err := json.Unmarshal([]byte(routesConfig), &routes); err != nil {
+		fmt.Println("Error parsing DIFY_ROUTES:", err)
+		return
+	}
+	for _, route := range routes {
+		if !difybot.HasApp(route.App) {
+			fmt.Println("DIFY_ROUTES 中的应用不存在:", route.App)
+			continue
+		}
+		appRoutes = append(appRoutes, route)
+	}
+}
+
+// 选择处理消息的dify应用, 返回应用名称和处理后的消息
+// 命中关键词时去掉消息开头的关键词, 没有命中任何规则时使用机器人绑定的应用
+func (r *Robot) routeApp(data *chatbot.BotCallbackDataModel, query string) (string, string) {
+	var deptIds []int64
+	deptLoaded := false
+	for _, route := range appRoutes {
+		switch {
+		case route.Keyword != "":
+			if rest, ok := cutKeyword(query, route.Keyword); ok {
+				return route.App, rest
+			}
+		case route.ConversationId != "":
+			if route.ConversationId == data.ConversationId {
+				return route.App, query
+			}
+		case route.DeptId != 0:
+			if !deptLoaded {
+				deptIds = r.senderDeptIds(data)
+				deptLoaded = true
+			}
+			for _, deptId := range deptIds {
+				if deptId == route.DeptId {
+					return route.App, query
+				}
+			}
+		}
+	}
+	return r.DifyApp, query
+}
+
+// 消息以关键词开头且关键词后是空白或消息结尾时, 返回去掉关键词后的消息
+// 例如关键词 #hr 匹配 "#hr 请假流程", 不匹配 "#hrfoo"
+func cutKeyword(query, keyword string) (string, bool) {
+	rest, ok := strings.CutPrefix(query, keyword)
+	if !ok {
+		return query, false
+	}
+	if next, _ := utf8.DecodeRuneInString(rest); rest != "" && !unicode.IsSpace(next) {
+		return query, false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// 查询发送者所在的部门
+func (r *Robot) senderDeptIds(data *chatbot.BotCallbackDataModel) []int64 {
+	if data.SenderStaffId == "" {
+		return nil
+	}
+	info, err := r.contacts.GetUserInfo(data.SenderStaffId)
+	if err != nil {
+		fmt.Println("Error getting user info:", err)
+		return nil
+	}
+	return info.DeptIdList
+}
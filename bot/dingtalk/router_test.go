@@ -0,0 +1,155 @@
+package dingbot
+
+import (
+	"ding/clients"
+	"ding/consts"
+	"fmt"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"testing"
+)
+
+// 测试用的通讯录, 记录查询次数
+type fakeContacts struct {
+	users       map[string]*clients.UserInfo
+	depts       map[int64]*clients.DeptInfo
+	userLookups int
+	deptLookups int
+}
+
+func (c *fakeContacts) GetUserInfo(userId string) (*clients.UserInfo, error) {
+	c.userLookups++
+	if info, ok := c.users[userId]; ok {
+		return info, nil
+	}
+	return nil, fmt.Errorf("user %s not found", userId)
+}
+
+func (c *fakeContacts) GetDeptInfo(deptId int64) (*clients.DeptInfo, error) {
+	c.deptLookups++
+	if info, ok := c.depts[deptId]; ok {
+		return info, nil
+	}
+	return nil, fmt.Errorf("dept %d not found", deptId)
+}
+
+// 设置路由表, 测试结束后恢复
+func setAppRoutes(t *testing.T, routes []appRoute) {
+	oldRoutes := appRoutes
+	appRoutes = routes
+	t.Cleanup(func() {
+		appRoutes = oldRoutes
+	})
+}
+
+func TestCutKeyword(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  string
+		ok    bool
+	}{
+		{"#hr 请假流程", "请假流程", true},
+		{"#hr\n请假流程", "请假流程", true},
+		{"#hr　请假流程", "请假流程", true},
+		{"#hr", "", true},
+		{"#hrfoo", "#hrfoo", false},
+		{"#hr请假", "#hr请假", false},
+		{"请假 #hr", "请假 #hr", false},
+		{"", "", false},
+	} {
+		got, ok := cutKeyword(tc.query, "#hr")
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("cutKeyword(%q) = %q, %v, want %q, %v", tc.query, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRouteApp(t *testing.T) {
+	setAppRoutes(t, []appRoute{
+		{App: "hr", Keyword: "#hr"},
+		{App: "it", ConversationId: "cid-it"},
+		{App: "code", DeptId: 20},
+		{App: "sales", DeptId: 30},
+	})
+	contacts := &fakeContacts{users: map[string]*clients.UserInfo{
+		"dev1":   {UserId: "dev1", DeptIdList: []int64{10, 20}},
+		"sales1": {UserId: "sales1", DeptIdList: []int64{30}},
+		"other1": {UserId: "other1", DeptIdList: []int64{40}},
+	}}
+	r := &Robot{DifyApp: "default", contacts: contacts}
+	for _, tc := range []struct {
+		name           string
+		staffId        string
+		conversationId string
+		query          string
+		wantApp        string
+		wantQuery      string
+	}{
+		{name: "keyword", staffId: "dev1", conversationId: "cid-it", query: "#hr 请假流程", wantApp: "hr", wantQuery: "请假流程"},
+		{name: "keyword without boundary", staffId: "other1", query: "#hrfoo", wantApp: "default", wantQuery: "#hrfoo"},
+		{name: "conversation", staffId: "dev1", conversationId: "cid-it", query: "打印机坏了", wantApp: "it", wantQuery: "打印机坏了"},
+		{name: "dept", staffId: "dev1", conversationId: "cid-other", query: "代码评审", wantApp: "code", wantQuery: "代码评审"},
+		{name: "second dept rule", staffId: "sales1", query: "报价", wantApp: "sales", wantQuery: "报价"},
+		{name: "no match", staffId: "other1", query: "你好", wantApp: "default", wantQuery: "你好"},
+		{name: "unknown sender", staffId: "nobody", query: "你好", wantApp: "default", wantQuery: "你好"},
+		{name: "no staff id", query: "你好", wantApp: "default", wantQuery: "你好"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &chatbot.BotCallbackDataModel{SenderStaffId: tc.staffId, ConversationId: tc.conversationId}
+			app, query := r.routeApp(data, tc.query)
+			if app != tc.wantApp || query != tc.wantQuery {
+				t.Errorf("routeApp(%q) = %q, %q, want %q, %q", tc.query, app, query, tc.wantApp, tc.wantQuery)
+			}
+		})
+	}
+}
+
+func TestRouteAppLooksUpDeptsOnce(t *testing.T) {
+	setAppRoutes(t, []appRoute{
+		{App: "code", DeptId: 20},
+		{App: "sales", DeptId: 30},
+	})
+	contacts := &fakeContacts{users: map[string]*clients.UserInfo{
+		"other1": {UserId: "other1", DeptIdList: []int64{40}},
+	}}
+	r := &Robot{DifyApp: "default", contacts: contacts}
+	r.routeApp(&chatbot.BotCallbackDataModel{SenderStaffId: "other1"}, "你好")
+	if contacts.userLookups != 1 {
+		t.Errorf("user lookups = %d, want 1", contacts.userLookups)
+	}
+}
+
+func TestAcceptRecognizedVoiceRoutesByText(t *testing.T) {
+	setAppRoutes(t, []appRoute{{App: "hr", Keyword: "#hr"}})
+	setWakeWord(t, []string{"小钉"}, wakeWordConfig{scope: wakeWordVoice})
+	r := &Robot{DifyApp: "default", contacts: &fakeContacts{}}
+	for _, tc := range []struct {
+		name      string
+		wakeWord  bool
+		text      string
+		wantOk    bool
+		wantApp   string
+		wantQuery string
+	}{
+		{name: "keyword", text: "#hr 请假流程", wantOk: true, wantApp: "hr", wantQuery: "请假流程"},
+		{name: "no keyword", text: "今天天气怎么样", wantOk: true, wantApp: "default", wantQuery: "今天天气怎么样"},
+		{name: "wake word then keyword", wakeWord: true, text: "小钉，#hr 请假流程", wantOk: true, wantApp: "hr", wantQuery: "请假流程"},
+		{name: "missing wake word", wakeWord: true, text: "#hr 请假流程", wantOk: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &DingMessage{
+				Robot:          r,
+				AppName:        "default",
+				Data:           &chatbot.BotCallbackDataModel{ConversationType: "2", ConversationId: "cid", Msgtype: consts.ReceivedTypeVoice},
+				MsgType:        consts.ReceivedTypeVoice,
+				ReceivedMsgStr: tc.text,
+				WakeWord:       tc.wakeWord,
+			}
+			if ok := msg.acceptRecognizedVoice(); ok != tc.wantOk {
+				t.Fatalf("acceptRecognizedVoice() = %v, want %v", ok, tc.wantOk)
+			}
+			if tc.wantOk && (msg.AppName != tc.wantApp || msg.ReceivedMsgStr != tc.wantQuery) {
+				t.Errorf("routed to %q, %q, want %q, %q", msg.AppName, msg.ReceivedMsgStr, tc.wantApp, tc.wantQuery)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package dingbot
+
+import (
+	"ding/consts"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+)
+
+// 会话范围, 通过 SESSION_SCOPE 配置, 默认按用户
+func sessionScope() string {
+	switch scope := os.Getenv("SESSION_SCOPE"); scope {
+	case consts.SessionScopeConversation, consts.SessionScopeUserConversation:
+		return scope
+	default:
+		return consts.SessionScopeUser
+	}
+}
+
+// 根据会话范围生成存储dify会话的key
+func sessionKey(data *chatbot.BotCallbackDataModel) string {
+	switch sessionScope() {
+	case consts.SessionScopeConversation:
+		return data.ConversationId
+	case consts.SessionScopeUserConversation:
+		return data.ConversationId + ":" + data.SenderId
+	default:
+		return data.SenderId
+	}
+}
+
+// 调用dify时使用的user
+// dify的会话归属于user, 群共享会话时需要用群id作为user才能继续同一个会话
+func difyUser(data *chatbot.BotCallbackDataModel) string {
+	if sessionScope() == consts.SessionScopeConversation {
+		return data.ConversationId
+	}
+	return data.SenderId
+}
@@ -0,0 +1,407 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"ding/clients"
+	"ding/consts"
+	selfutils "ding/utils"
+	audio "ding/voices"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 转写任务在存储中的key
+	transcriptionJobKeyPrefix = "transcribe:job:"
+	transcriptionJobIndexKey  = "transcribe:jobs"
+	// 处理转写任务的租约, 多实例部署时同一任务只由一个实例处理
+	transcriptionLeaseKeyPrefix = "transcribe:lease:"
+	// 租约的有效期, 处理期间按三分之一的有效期续期, 实例退出后由其他实例接手
+	transcriptionLeaseTTL = 3 * time.Minute
+	// 转写任务状态的保留时间, 也是任务的最长等待时间
+	transcriptionJobTTL = 24 * time.Hour
+	// 查询转写结果的轮询间隔, 每次增加一半, 不超过最大间隔
+	transcriptionPollInterval    = 5 * time.Second
+	transcriptionMaxPollInterval = time.Minute
+	// 卡片中展示的转写内容长度, 超过时完整内容以文件发送
+	transcriptMaxCardRunes = 2000
+	defaultSummaryPrompt   = "请总结以下会议录音的转写内容，列出要点和待办事项：\n\n"
+)
+
+var (
+	// 作为录音转写的文件格式
+	audioFileExtensions = []string{".mp3", ".wav", ".m4a", ".amr", ".ogg", ".aac", ".flac", ".opus", ".pcm"}
+	// 保护转写任务索引的读写
+	transcriptionIndexMu sync.Mutex
+	// 当前实例的标识, 作为转写任务租约的持有者
+	transcriptionOwner = uuid.NewString()
+	// 当前实例正在处理的转写任务
+	runningTranscriptionJobs sync.Map
+)
+
+// 后台录音转写任务, 保存到会话存储中, 重启后继续查询
+type transcriptionJob struct {
+	ID               string        `json:"id"` // 即进度卡片的cardBizId
+	OrderId          string        `json:"order_id"`
+	RobotName        string        `json:"robot_name"`
+	AppName          string        `json:"app_name"`
+	ConversationId   string        `json:"conversation_id"`
+	ConversationType string        `json:"conversation_type"`
+	SenderId         string        `json:"sender_id"`
+	SenderStaffId    string        `json:"sender_staff_id"`
+	FileName         string        `json:"file_name"`
+	Estimate         time.Duration `json:"estimate"`
+	CreatedAt        time.Time     `json:"created_at"`
+}
+
+// 是否开启录音文件转写, 需要配置讯飞录音文件转写
+func transcriptionEnabled() bool {
+	return os.Getenv("XUNFEI_APPID") != ""
+}
+
+// 是否为需要转写的录音文件
+func isAudioFile(fileName string) bool {
+	return selfutils.StringInSlice(strings.ToLower(filepath.Ext(fileName)), audioFileExtensions)
+}
+
+func transcriptionStore() difybot.SessionStore {
+	return difybot.DifyClient.Store
+}
+
+// 上传录音并创建后台转写任务, 不阻塞消息消费者
+func (msg *DingMessage) startTranscription() {
+	u, err := uuid.NewUUID()
+	if err != nil {
+		fmt.Println("生成uuid错误")
+		return
+	}
+	msg.CardInstanceId = u.String()
+	msg.Robot.sendInteractiveCard(msg.CardInstanceId, msg, buildCardData(true, fmt.Sprintf("**%s**\n\n录音下载中", msg.FileName)))
+
+	job := &transcriptionJob{
+		ID:               msg.CardInstanceId,
+		RobotName:        msg.Robot.Name,
+		AppName:          msg.AppName,
+		ConversationId:   msg.Data.ConversationId,
+		ConversationType: msg.Data.ConversationType,
+		SenderId:         msg.Data.SenderId,
+		SenderStaffId:    msg.Data.SenderStaffId,
+		FileName:         msg.FileName,
+		CreatedAt:        time.Now(),
+	}
+	data, err := selfutils.DownloadFile(msg.FileUrl)
+	if err != nil {
+		fmt.Println("Error downloading audio file:", err)
+		job.finish(msg, "录音下载失败")
+		return
+	}
+	job.updateProgress(msg, "录音上传中")
+	orderId, estimate, err := audio.NewXunfeiRecognizer().Submit(data, msg.FileName)
+	if err != nil {
+		fmt.Println("Error submitting transcription:", err)
+		job.finish(msg, "录音上传失败")
+		return
+	}
+	job.OrderId = orderId
+	job.Estimate = estimate
+	job.save()
+	job.start(msg)
+}
+
+// 启动时继续查询未完成的转写任务, 之后定期接手租约已过期的任务
+func watchTranscriptionJobs(robots []*Robot) {
+	for {
+		resumeTranscriptionJobs(robots)
+		time.Sleep(transcriptionLeaseTTL / 3)
+	}
+}
+
+// 继续查询未完成的转写任务, 跳过其他实例正在处理的任务
+func resumeTranscriptionJobs(robots []*Robot) {
+	for _, id := range loadTranscriptionJobIds() {
+		value, ok := transcriptionStore().Get(transcriptionJobKeyPrefix + id)
+		if !ok {
+			removeTranscriptionJobId(id)
+			continue
+		}
+		var job transcriptionJob
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			fmt.Println("Error unmarshalling transcription job:", err)
+			removeTranscriptionJobId(id)
+			continue
+		}
+		for _, r := range robots {
+			if r.Name == job.RobotName {
+				if job.start(job.message(r)) {
+					fmt.Println("继续录音转写任务:", job.ID, job.FileName)
+				}
+				break
+			}
+		}
+	}
+}
+
+// 获取租约后在后台处理任务, 当前实例已在处理或其他实例持有租约时返回false
+func (job *transcriptionJob) start(msg *DingMessage) bool {
+	if !job.acquire() {
+		return false
+	}
+	go func() {
+		defer job.release()
+		stop := make(chan struct{})
+		defer close(stop)
+		go job.keepLease(stop)
+		job.run(msg)
+	}()
+	return true
+}
+
+// 当前实例开始处理任务
+func (job *transcriptionJob) acquire() bool {
+	if _, running := runningTranscriptionJobs.LoadOrStore(job.ID, true); running {
+		return false
+	}
+	if !job.claim() {
+		runningTranscriptionJobs.Delete(job.ID)
+		return false
+	}
+	return true
+}
+
+func (job *transcriptionJob) release() {
+	runningTranscriptionJobs.Delete(job.ID)
+}
+
+// 获取或续期任务的租约, 其他实例持有租约时返回false
+// 存储出错时继续处理, 避免任务中断
+func (job *transcriptionJob) claim() bool {
+	ok, err := transcriptionStore().Lease(transcriptionLeaseKeyPrefix+job.ID, transcriptionOwner, transcriptionLeaseTTL)
+	if err != nil {
+		fmt.Println("Error claiming transcription job:", err)
+		return true
+	}
+	return ok
+}
+
+// 处理期间定期续期租约, 总结等耗时的操作不会让租约过期
+func (job *transcriptionJob) keepLease(stop <-chan struct{}) {
+	ticker := time.NewTicker(transcriptionLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			job.claim()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 还原发送卡片和消息需要的DingMessage
+func (job *transcriptionJob) message(r *Robot) *DingMessage {
+	return &DingMessage{
+		Robot:   r,
+		AppName: job.AppName,
+		Data: &chatbot.BotCallbackDataModel{
+			ConversationId:   job.ConversationId,
+			ConversationType: job.ConversationType,
+			SenderId:         job.SenderId,
+			SenderStaffId:    job.SenderStaffId,
+		},
+		MsgType:        consts.ReceivedTypeFile,
+		IsGroup:        job.ConversationType == "2",
+		CardInstanceId: job.ID,
+		FileName:       job.FileName,
+	}
+}
+
+// 轮询转写结果, 更新进度卡片, 完成后回复转写内容
+func (job *transcriptionJob) run(msg *DingMessage) {
+	recognizer := audio.NewXunfeiRecognizer()
+	interval := transcriptionPollInterval
+	for time.Since(job.CreatedAt) < transcriptionJobTTL {
+		if !job.claim() {
+			fmt.Println("转写任务已由其他实例处理:", job.ID)
+			return
+		}
+		result, err := recognizer.Query(job.OrderId)
+		if err != nil {
+			fmt.Println("Error querying transcription:", err)
+		} else {
+			switch result.Content.OrderInfo.Status {
+			case audio.XunfeiStatusDone:
+				text, err := result.Text()
+				if err != nil {
+					fmt.Println("Error extracting transcript:", err)
+					job.finish(msg, "转写结果解析失败")
+					return
+				}
+				job.complete(msg, text)
+				return
+			case audio.XunfeiStatusFailed:
+				fmt.Println("transcription failed:", result.DescInfo)
+				job.finish(msg, "转写失败: "+result.DescInfo)
+				return
+			}
+			job.updateProgress(msg, job.progressText())
+		}
+		time.Sleep(interval)
+		interval = audio.NextPollInterval(interval, transcriptionMaxPollInterval)
+	}
+	job.finish(msg, "转写超时")
+}
+
+func (job *transcriptionJob) progressText() string {
+	elapsed := time.Since(job.CreatedAt).Round(time.Second)
+	if job.Estimate > 0 {
+		return fmt.Sprintf("正在转写，已用时 %s，预计需要 %s", elapsed, job.Estimate.Round(time.Second))
+	}
+	return fmt.Sprintf("正在转写，已用时 %s", elapsed)
+}
+
+func (job *transcriptionJob) updateProgress(msg *DingMessage, status string) {
+	cardData := buildCardData(true, fmt.Sprintf("**%s**\n\n%s", job.FileName, status))
+	if err := msg.Robot.UpdateDingTalkCard(cardData, job.ID); err != nil {
+		fmt.Println("Error updating DingTalk card:", err)
+	}
+}
+
+// 任务结束, 更新卡片并删除任务状态
+func (job *transcriptionJob) finish(msg *DingMessage, content string) {
+	cardData := buildCardData(false, fmt.Sprintf("**%s**\n\n%s", job.FileName, content))
+	if err := msg.Robot.UpdateDingTalkCard(cardData, job.ID); err != nil {
+		fmt.Println("Error updating DingTalk card:", err)
+	}
+	job.remove()
+}
+
+// 转写完成, 按需用dify总结, 转写内容过长时完整内容以文件发送
+func (job *transcriptionJob) complete(msg *DingMessage, transcript string) {
+	transcript = strings.TrimSpace(transcript)
+	if transcript == "" {
+		job.finish(msg, "没有识别到内容")
+		return
+	}
+	var builder strings.Builder
+	if os.Getenv("TRANSCRIBE_SUMMARY") == "true" {
+		job.updateProgress(msg, "转写完成，正在总结")
+		if summary := job.summarize(msg, transcript); summary != "" {
+			builder.WriteString("### 总结\n\n" + summary + "\n\n### 转写内容\n\n")
+		}
+	}
+	runes := []rune(transcript)
+	if len(runes) <= transcriptMaxCardRunes {
+		builder.WriteString(transcript)
+	} else {
+		builder.WriteString(string(runes[:transcriptMaxCardRunes]) + "……")
+		if err := job.sendTranscriptFile(msg, transcript); err != nil {
+			fmt.Println("Error sending transcript file:", err)
+			builder.WriteString("\n\n（内容过长，仅展示部分）")
+		} else {
+			builder.WriteString("\n\n（完整内容见转写文件）")
+		}
+	}
+	job.finish(msg, builder.String())
+}
+
+// 用dify总结转写内容, TRANSCRIBE_SUMMARY_PROMPT 为总结的提示词
+func (job *transcriptionJob) summarize(msg *DingMessage, transcript string) string {
+	prompt := os.Getenv("TRANSCRIBE_SUMMARY_PROMPT")
+	if prompt == "" {
+		prompt = defaultSummaryPrompt
+	}
+	// 总结不使用也不保存会话
+	summary, err := difybot.App(job.AppName).CallAPIBlock(prompt+transcript, "", difyUser(msg.Data), "", nil)
+	if err != nil {
+		fmt.Println("Error summarizing transcript:", err)
+		return ""
+	}
+	return summary
+}
+
+func (job *transcriptionJob) sendTranscriptFile(msg *DingMessage, transcript string) error {
+	fileName := strings.TrimSuffix(job.FileName, filepath.Ext(job.FileName)) + ".txt"
+	mediaId, err := msg.Robot.Client.UploadMedia(clients.MediaTypeFile, fileName, []byte(transcript))
+	if err != nil {
+		return err
+	}
+	return msg.Robot.sendRobotMessage(msg, "sampleFile", map[string]string{
+		"mediaId":  mediaId,
+		"fileName": fileName,
+		"fileType": "txt",
+	})
+}
+
+// 保存任务状态
+func (job *transcriptionJob) save() {
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		fmt.Println("Error marshalling transcription job:", err)
+		return
+	}
+	if err = transcriptionStore().Set(transcriptionJobKeyPrefix+job.ID, string(jobData), transcriptionJobTTL); err != nil {
+		fmt.Println("Error saving transcription job:", err)
+		return
+	}
+	transcriptionIndexMu.Lock()
+	defer transcriptionIndexMu.Unlock()
+	ids := loadTranscriptionJobIds()
+	saveTranscriptionJobIds(append(ids, job.ID))
+}
+
+func (job *transcriptionJob) remove() {
+	if err := transcriptionStore().Delete(transcriptionJobKeyPrefix + job.ID); err != nil {
+		fmt.Println("Error deleting transcription job:", err)
+	}
+	removeTranscriptionJobId(job.ID)
+	if err := transcriptionStore().Delete(transcriptionLeaseKeyPrefix + job.ID); err != nil {
+		fmt.Println("Error deleting transcription lease:", err)
+	}
+}
+
+func loadTranscriptionJobIds() []string {
+	value, ok := transcriptionStore().Get(transcriptionJobIndexKey)
+	if !ok {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		fmt.Println("Error unmarshalling transcription job index:", err)
+		return nil
+	}
+	return ids
+}
+
+func saveTranscriptionJobIds(ids []string) {
+	if len(ids) == 0 {
+		transcriptionStore().Delete(transcriptionJobIndexKey)
+		return
+	}
+	indexData, err := json.Marshal(ids)
+	if err != nil {
+		fmt.Println("Error marshalling transcription job index:", err)
+		return
+	}
+	if err = transcriptionStore().Set(transcriptionJobIndexKey, string(indexData), transcriptionJobTTL); err != nil {
+		fmt.Println("Error saving transcription job index:", err)
+	}
+}
+
+func removeTranscriptionJobId(id string) {
+	transcriptionIndexMu.Lock()
+	defer transcriptionIndexMu.Unlock()
+	ids := []string{}
+	for _, jobId := range loadTranscriptionJobIds() {
+		if jobId != id {
+			ids = append(ids, jobId)
+		}
+	}
+	saveTranscriptionJobIds(ids)
+}
@@ -0,0 +1,51 @@
+package dingbot
+
+import (
+	"ding/bot/difybot"
+	"testing"
+)
+
+// 设置当前实例的标识, 模拟多个实例, 测试结束后恢复
+func setTranscriptionOwner(t *testing.T, owner string) {
+	oldOwner := transcriptionOwner
+	transcriptionOwner = owner
+	t.Cleanup(func() {
+		transcriptionOwner = oldOwner
+	})
+}
+
+func TestTranscriptionJobAcquire(t *testing.T) {
+	oldStore := difybot.DifyClient.Store
+	store := difybot.NewMemorySessionStore()
+	difybot.DifyClient.Store = store
+	t.Cleanup(func() {
+		store.Close()
+		difybot.DifyClient.Store = oldStore
+	})
+	job := &transcriptionJob{ID: "job1"}
+
+	setTranscriptionOwner(t, "replica1")
+	if !job.acquire() {
+		t.Fatalf("acquire by replica1 = false, want true")
+	}
+	// 同一实例正在处理时不重复启动
+	if job.acquire() {
+		t.Errorf("acquire while running = true, want false")
+	}
+
+	// 其他实例持有租约时跳过
+	setTranscriptionOwner(t, "replica2")
+	job.release()
+	if job.acquire() {
+		t.Errorf("acquire by replica2 while leased = true, want false")
+	}
+
+	// 任务结束删除租约后其他实例可以获取
+	setTranscriptionOwner(t, "replica1")
+	job.remove()
+	setTranscriptionOwner(t, "replica2")
+	if !job.acquire() {
+		t.Errorf("acquire by replica2 after remove = false, want true")
+	}
+	job.release()
+}
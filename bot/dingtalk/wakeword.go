@@ -0,0 +1,182 @@
+package dingbot
+
+import (
+	"ding/consts"
+	"encoding/json"
+	"fmt"
+	"github.com/mozillazg/go-pinyin"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// 唤醒词的使用范围
+const (
+	// 不需要唤醒词
+	wakeWordOff = "off"
+	// 群聊中的语音消息需要包含唤醒词
+	wakeWordVoice = "voice"
+	// 群聊中的语音和文字消息都需要包含唤醒词
+	wakeWordAll = "all"
+)
+
+var (
+	// 模糊匹配时视为相同的声母和韵母, 语音识别容易混淆平翹舌、前后鼻音和n/l
+	fuzzyPinyinReplacer = strings.NewReplacer("zh", "z", "ch", "c", "sh", "s", "ang", "an", "eng", "en", "ing", "in")
+	// 唤醒词前后需要去掉的标点
+	wakeWordTrimChars = " ,，.。!！?？:：、~～"
+)
+
+// 唤醒词配置, 唤醒词本身为 VOICE_KEYWORDS
+type wakeWordConfig struct {
+	scope string            // WAKE_WORD_SCOPE
+	chats map[string]string // WAKE_WORD_CHATS, 按群的ConversationId配置范围
+	fuzzy bool              // WAKE_WORD_FUZZY, 按拼音模糊匹配
+}
+
+var wakeWord wakeWordConfig
+
+// 加载唤醒词配置
+// WAKE_WORD_CHATS 格式为JSON, 例如 {"cid...":"all","cid...":"off"}
+func loadWakeWord() {
+	wakeWord = wakeWordConfig{
+		scope: os.Getenv("WAKE_WORD_SCOPE"),
+		fuzzy: os.Getenv("WAKE_WORD_FUZZY") == "true",
+	}
+	if wakeWord.scope == "" {
+		wakeWord.scope = wakeWordOff
+	}
+	if chats := os.Getenv("WAKE_WORD_CHATS"); chats != "" {
+		if err := json.Unmarshal([]byte(chats), &wakeWord.chats); err != nil {
+			fmt.Println("Error parsing WAKE_WORD_CHATS:", err)
+			wakeWord.chats = nil
+		}
+	}
+	for _, scope := range append([]string{wakeWord.scope}, chatScopes(wakeWord.chats)...) {
+		if scope != wakeWordOff && scope != wakeWordVoice && scope != wakeWordAll {
+			fmt.Println("不支持的唤醒词范围:", scope)
+		}
+	}
+}
+
+func chatScopes(chats map[string]string) []string {
+	scopes := make([]string, 0, len(chats))
+	for _, scope := range chats {
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// 会话使用的唤醒词范围, 单聊不需要唤醒词
+func wakeWordScope(data *chatbot.BotCallbackDataModel) string {
+	if data.ConversationType != "2" || len(consts.VoicePrefix) == 0 {
+		return wakeWordOff
+	}
+	if scope, ok := wakeWord.chats[data.ConversationId]; ok {
+		return scope
+	}
+	return wakeWord.scope
+}
+
+// 消息是否需要包含唤醒词
+func wakeWordRequired(data *chatbot.BotCallbackDataModel, msgType string) bool {
+	switch wakeWordScope(data) {
+	case wakeWordAll:
+		return msgType == consts.ReceivedTypeVoice || msgType == consts.ReceivedTypeText || msgType == consts.ReceivedTypeRichText
+	case wakeWordVoice:
+		return msgType == consts.ReceivedTypeVoice
+	}
+	return false
+}
+
+// 查找 VOICE_KEYWORDS 中的唤醒词, 找到时返回去掉唤醒词后的内容
+// WAKE_WORD_FUZZY=true 时按拼音模糊匹配
+func matchWakeWord(text string) (string, bool) {
+	for _, keyword := range consts.VoicePrefix {
+		if index := strings.Index(text, keyword); index >= 0 {
+			return stripWakeWord([]rune(text[:index]), []rune(text[index+len(keyword):])), true
+		}
+	}
+	if !wakeWord.fuzzy {
+		return text, false
+	}
+	runes := []rune(text)
+	textPinyin := runePinyin(runes)
+	for _, keyword := range consts.VoicePrefix {
+		keywordPinyin := runePinyin([]rune(keyword))
+		for start := 0; start+len(keywordPinyin) <= len(runes); start++ {
+			if pinyinMatch(textPinyin[start:start+len(keywordPinyin)], keywordPinyin) {
+				return stripWakeWord(runes[:start], runes[start+len(keywordPinyin):]), true
+			}
+		}
+	}
+	return text, false
+}
+
+// 拼接唤醒词前后的内容, 去掉唤醒词附近的标点
+func stripWakeWord(before, after []rune) string {
+	prefix := strings.TrimRight(string(before), wakeWordTrimChars)
+	suffix := strings.TrimLeft(string(after), wakeWordTrimChars)
+	if prefix != "" && suffix != "" {
+		return prefix + " " + suffix
+	}
+	return prefix + suffix
+}
+
+// 每个字的模糊拼音, 多音字保留所有读音, 非汉字使用小写的字符本身
+func runePinyin(runes []rune) [][]string {
+	args := pinyin.NewArgs()
+	args.Heteronym = true
+	result := make([][]string, len(runes))
+	for i, r := range runes {
+		readings := pinyin.SinglePinyin(r, args)
+		if len(readings) == 0 {
+			result[i] = []string{string(unicode.ToLower(r))}
+			continue
+		}
+		for _, reading := range readings {
+			result[i] = append(result[i], fuzzyPinyin(reading))
+		}
+	}
+	return result
+}
+
+func fuzzyPinyin(reading string) string {
+	reading = fuzzyPinyinReplacer.Replace(reading)
+	if strings.HasPrefix(reading, "n") {
+		reading = "l" + reading[1:]
+	}
+	return reading
+}
+
+// 每个字都有相同的读音时匹配
+func pinyinMatch(text, keyword [][]string) bool {
+	for i := range keyword {
+		matched := false
+		for _, reading := range keyword[i] {
+			for _, candidate := range text[i] {
+				if reading == candidate {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// 检查消息是否包含唤醒词, 不需要唤醒词时原样返回
+// 返回false时不回复这条消息
+func checkWakeWord(data *chatbot.BotCallbackDataModel, msgType, text string) (string, bool) {
+	if !wakeWordRequired(data, msgType) {
+		return text, true
+	}
+	query, ok := matchWakeWord(text)
+	if !ok {
+		fmt.Println("消息不包含唤醒词, 忽略:", text)
+	}
+	return query, ok
+}
@@ -0,0 +1,93 @@
+package dingbot
+
+import (
+	"ding/consts"
+	"github.com/open-dingtalk/dingtalk-stream-sdk-go/chatbot"
+	"testing"
+)
+
+// 设置唤醒词和配置, 测试结束后恢复
+func setWakeWord(t *testing.T, keywords []string, config wakeWordConfig) {
+	oldKeywords, oldConfig := consts.VoicePrefix, wakeWord
+	consts.VoicePrefix, wakeWord = keywords, config
+	t.Cleanup(func() {
+		consts.VoicePrefix, wakeWord = oldKeywords, oldConfig
+	})
+}
+
+func TestFuzzyPinyin(t *testing.T) {
+	for _, tc := range []struct {
+		reading string
+		want    string
+	}{
+		{"xiao", "xiao"},
+		{"zhang", "zan"},
+		{"chen", "cen"},
+		{"shi", "si"},
+		{"ding", "din"},
+		{"feng", "fen"},
+		{"ni", "li"},
+		{"neng", "len"},
+		{"li", "li"},
+	} {
+		if got := fuzzyPinyin(tc.reading); got != tc.want {
+			t.Errorf("fuzzyPinyin(%q) = %q, want %q", tc.reading, got, tc.want)
+		}
+	}
+}
+
+func TestMatchWakeWord(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		fuzzy bool
+		text  string
+		want  string
+		ok    bool
+	}{
+		{name: "prefix", text: "小钉，今天天气怎么样", want: "今天天气怎么样", ok: true},
+		{name: "middle", text: "你好小钉帮我查一下", want: "你好 帮我查一下", ok: true},
+		{name: "suffix", text: "帮我查一下。小钉", want: "帮我查一下", ok: true},
+		{name: "only wake word", text: "小钉！", want: "", ok: true},
+		{name: "missing", text: "今天天气怎么样", want: "今天天气怎么样", ok: false},
+		{name: "homophone without fuzzy", text: "晓丁，查一下", want: "晓丁，查一下", ok: false},
+		{name: "homophone", fuzzy: true, text: "晓丁，查一下", want: "查一下", ok: true},
+		{name: "front and back nasal", fuzzy: true, text: "小民帮我查一下", want: "帮我查一下", ok: true},
+		{name: "n and l", fuzzy: true, text: "小兰帮我查一下", want: "帮我查一下", ok: true},
+		{name: "first match", fuzzy: true, text: "小宁小鼎", want: "小宁", ok: true},
+		{name: "different syllable", fuzzy: true, text: "小刀帮我查一下", want: "小刀帮我查一下", ok: false},
+		{name: "latin case insensitive", fuzzy: true, text: "Hey bot 你好", want: "你好", ok: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setWakeWord(t, []string{"小钉", "小明", "小南", "hey bot"}, wakeWordConfig{scope: wakeWordVoice, fuzzy: tc.fuzzy})
+			got, ok := matchWakeWord(tc.text)
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("matchWakeWord(%q) = %q, %v, want %q, %v", tc.text, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestWakeWordRequired(t *testing.T) {
+	setWakeWord(t, []string{"小钉"}, wakeWordConfig{
+		scope: wakeWordVoice,
+		chats: map[string]string{"cid-all": wakeWordAll, "cid-off": wakeWordOff},
+	})
+	for _, tc := range []struct {
+		conversationType string
+		conversationId   string
+		msgType          string
+		want             bool
+	}{
+		{"1", "cid-all", consts.ReceivedTypeVoice, false},
+		{"2", "cid-other", consts.ReceivedTypeVoice, true},
+		{"2", "cid-other", consts.ReceivedTypeText, false},
+		{"2", "cid-all", consts.ReceivedTypeText, true},
+		{"2", "cid-all", consts.ReceivedTypeImage, false},
+		{"2", "cid-off", consts.ReceivedTypeVoice, false},
+	} {
+		data := &chatbot.BotCallbackDataModel{ConversationType: tc.conversationType, ConversationId: tc.conversationId}
+		if got := wakeWordRequired(data, tc.msgType); got != tc.want {
+			t.Errorf("wakeWordRequired(%s, %s, %s) = %v, want %v", tc.conversationType, tc.conversationId, tc.msgType, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	mediaUploadUrl = "https://oapi.dingtalk.com/media/upload?access_token=%s&type=%s"
+)
+
+// 媒体文件类型
+const (
+	MediaTypeImage = "image"
+	MediaTypeVoice = "voice"
+	MediaTypeFile  = "file"
+)
+
+// 各类型媒体文件的大小限制, 超过后钉钉会拒绝上传
+var MediaSizeLimits = map[string]int{
+	MediaTypeImage: 20 * 1024 * 1024,
+	MediaTypeVoice: 2 * 1024 * 1024,
+	MediaTypeFile:  20 * 1024 * 1024,
+}
+
+type mediaUploadResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	Type    string `json:"type"`
+	MediaId string `json:"media_id"`
+}
+
+// 上传媒体文件, 返回mediaId, 可用于发送图片, 语音和文件消息
+func (c *DingTalkClient) UploadMedia(mediaType, fileName string, data []byte) (string, error) {
+	if limit, ok := MediaSizeLimits[mediaType]; ok && len(data) > limit {
+		return "", fmt.Errorf("media size %d exceeds limit %d", len(data), limit)
+	}
+	accessToken, err := c.GetAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("media", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(fmt.Sprintf(mediaUploadUrl, accessToken, mediaType), writer.FormDataContentType(), body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var uploadResp mediaUploadResponse
+	if err = json.Unmarshal(respBody, &uploadResp); err != nil {
+		return "", err
+	}
+	if uploadResp.ErrCode != 0 {
+		return "", fmt.Errorf("upload media failed: %d %s", uploadResp.ErrCode, uploadResp.ErrMsg)
+	}
+	return uploadResp.MediaId, nil
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/alibabacloud-go/dingtalk/robot_1_0"
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
-	"os"
 	"time"
 )
 
@@ -21,15 +20,6 @@ type DingTalkClient struct {
 	robotClient   *robot_1_0.Client
 }
 
-var (
-	DingtalkClient1 *DingTalkClient = nil
-)
-
-func DingTalkStreamClientInit() {
-	clientId := os.Getenv("CLIENT_ID")
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	DingtalkClient1 = NewDingTalkClient(clientId, clientSecret)
-}
 func NewDingTalkClient(clientId, clientSecret string) *DingTalkClient {
 	config := &openapi.Config{}
 	config.Protocol = tea.String("https")
@@ -160,3 +150,59 @@ func (c *DingTalkClient) RobotMessageFileDownload(request *robot_1_0.RobotMessag
 	}
 	return response, nil
 }
+
+func (c *DingTalkClient) OrgGroupSend(request *robot_1_0.OrgGroupSendRequest) (*robot_1_0.OrgGroupSendResponse, error) {
+	accessToken, err := c.GetAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := &robot_1_0.OrgGroupSendHeaders{
+		XAcsDingtalkAccessToken: tea.String(accessToken),
+	}
+	request.RobotCode = &c.ClientID
+	response, tryErr := func() (_resp *robot_1_0.OrgGroupSendResponse, _e error) {
+		defer func() {
+			if r := tea.Recover(recover()); r != nil {
+				_e = r
+			}
+		}()
+		_resp, _e = c.robotClient.OrgGroupSendWithOptions(request, headers, &util.RuntimeOptions{})
+		if _e != nil {
+			return
+		}
+		return
+	}()
+	if tryErr != nil {
+		return nil, tryErr
+	}
+	return response, nil
+}
+
+func (c *DingTalkClient) BatchSendOTO(request *robot_1_0.BatchSendOTORequest) (*robot_1_0.BatchSendOTOResponse, error) {
+	accessToken, err := c.GetAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := &robot_1_0.BatchSendOTOHeaders{
+		XAcsDingtalkAccessToken: tea.String(accessToken),
+	}
+	request.RobotCode = &c.ClientID
+	response, tryErr := func() (_resp *robot_1_0.BatchSendOTOResponse, _e error) {
+		defer func() {
+			if r := tea.Recover(recover()); r != nil {
+				_e = r
+			}
+		}()
+		_resp, _e = c.robotClient.BatchSendOTOWithOptions(request, headers, &util.RuntimeOptions{})
+		if _e != nil {
+			return
+		}
+		return
+	}()
+	if tryErr != nil {
+		return nil, tryErr
+	}
+	return response, nil
+}
@@ -0,0 +1,150 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	userInfoUrl = "https://oapi.dingtalk.com/topapi/v2/user/get?access_token=%s"
+	// 用户信息缓存时间
+	userInfoCacheDuration = time.Hour
+)
+
+// 钉钉通讯录中的用户信息
+type UserInfo struct {
+	UserId     string  `json:"userid"`
+	Name       string  `json:"name"`
+	Title      string  `json:"title"`
+	JobNumber  string  `json:"job_number"`
+	DeptIdList []int64 `json:"dept_id_list"`
+	Admin      bool    `json:"admin"` // 是否为企业管理员
+}
+
+type userInfoResponse struct {
+	ErrCode int      `json:"errcode"`
+	ErrMsg  string   `json:"errmsg"`
+	Result  UserInfo `json:"result"`
+}
+
+type userInfoCacheEntry struct {
+	info     *UserInfo
+	expireAt time.Time
+}
+
+var (
+	// key为ClientID:userId
+	userInfoCache sync.Map
+)
+
+// 通过staffId查询用户信息, 结果缓存1小时
+// 需要应用开通通讯录个人信息读权限
+func (c *DingTalkClient) GetUserInfo(userId string) (*UserInfo, error) {
+	cacheKey := c.ClientID + ":" + userId
+	if value, ok := userInfoCache.Load(cacheKey); ok {
+		entry := value.(userInfoCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			return entry.info, nil
+		}
+	}
+
+	accessToken, err := c.GetAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(map[string]string{"userid": userId, "language": "zh_CN"})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(fmt.Sprintf(userInfoUrl, accessToken), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var infoResp userInfoResponse
+	if err = json.Unmarshal(body, &infoResp); err != nil {
+		return nil, err
+	}
+	if infoResp.ErrCode != 0 {
+		return nil, fmt.Errorf("get user info failed: %d %s", infoResp.ErrCode, infoResp.ErrMsg)
+	}
+	info := infoResp.Result
+	userInfoCache.Store(cacheKey, userInfoCacheEntry{info: &info, expireAt: time.Now().Add(userInfoCacheDuration)})
+	return &info, nil
+}
+
+const (
+	deptInfoUrl = "https://oapi.dingtalk.com/topapi/v2/department/get?access_token=%s"
+)
+
+// 钉钉通讯录中的部门信息
+type DeptInfo struct {
+	DeptId   int64  `json:"dept_id"`
+	Name     string `json:"name"`
+	ParentId int64  `json:"parent_id"`
+}
+
+type deptInfoResponse struct {
+	ErrCode int      `json:"errcode"`
+	ErrMsg  string   `json:"errmsg"`
+	Result  DeptInfo `json:"result"`
+}
+
+type deptInfoCacheEntry struct {
+	info     *DeptInfo
+	expireAt time.Time
+}
+
+var (
+	// key为ClientID:deptId
+	deptInfoCache sync.Map
+)
+
+// 通过部门id查询部门信息, 结果缓存1小时
+// 需要应用开通通讯录部门信息读权限
+func (c *DingTalkClient) GetDeptInfo(deptId int64) (*DeptInfo, error) {
+	cacheKey := fmt.Sprintf("%s:%d", c.ClientID, deptId)
+	if value, ok := deptInfoCache.Load(cacheKey); ok {
+		entry := value.(deptInfoCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			return entry.info, nil
+		}
+	}
+
+	accessToken, err := c.GetAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{"dept_id": deptId, "language": "zh_CN"})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(fmt.Sprintf(deptInfoUrl, accessToken), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var infoResp deptInfoResponse
+	if err = json.Unmarshal(body, &infoResp); err != nil {
+		return nil, err
+	}
+	if infoResp.ErrCode != 0 {
+		return nil, fmt.Errorf("get dept info failed: %d %s", infoResp.ErrCode, infoResp.ErrMsg)
+	}
+	info := infoResp.Result
+	deptInfoCache.Store(cacheKey, deptInfoCacheEntry{info: &info, expireAt: time.Now().Add(userInfoCacheDuration)})
+	return &info, nil
+}
@@ -28,6 +28,11 @@ func LoadConfig() error {
 		fmt.Println("No keywords found in environment")
 	}
 	// 将语音关键词字符串分割为 slice
-	consts.VoicePrefix = strings.Split(VoiceKeywords, ",")
+	consts.VoicePrefix = []string{}
+	for _, keyword := range strings.Split(VoiceKeywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			consts.VoicePrefix = append(consts.VoicePrefix, keyword)
+		}
+	}
 	return nil
 }
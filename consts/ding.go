@@ -1,30 +1,7 @@
 package consts
 
 const (
-	MessageCardTemplateWithTitle1 = `
-{
-  "config": {
-    "autoLayout": true,
-    "enableForward": true
-  },
-  "contents": [
-    {
-      "type": "markdown",
-      "text": "![loading](https://dify-oss-test.oss-cn-shanghai.aliyuncs.com/gif/loading_gif50.gif)",
- 		"id": "text_1693929551595"
-    },
-    {
-      "type": "divider",
-      "id": "divider_1693929551595"
-    },
-    {
-      "type": "markdown",
-      "text": "%s ",
-      "id": "markdown_1693929674245"
-    }
-  ]
-}
-`
+	LoadingGifUrl = "https://dify-oss-test.oss-cn-shanghai.aliyuncs.com/gif/loading_gif50.gif"
 
 	MessageCardTemplateWithoutTitle = `
 {
@@ -48,9 +25,27 @@ const (
 	OutputTypeStream   = "Stream"
 	OutputTypeMarkDown = "MarkDown"
 
-	ReceivedTypeText  = "text"
-	ReceivedTypeImage = "picture"
-	ReceivedTypeVoice = "audio"
+	ReceivedTypeText     = "text"
+	ReceivedTypeImage    = "picture"
+	ReceivedTypeVoice    = "audio"
+	ReceivedTypeRichText = "richText"
+	ReceivedTypeFile     = "file"
+)
+
+const (
+	// 会话范围
+	SessionScopeUser             = "user"              // 每个用户一个会话, 跨群和私聊共享
+	SessionScopeConversation     = "conversation"      // 每个群/私聊一个会话, 群成员共享上下文
+	SessionScopeUserConversation = "user_conversation" // 每个群内的每个用户一个会话
+)
+
+const (
+	// 只发送图片没有文字时使用的默认问题
+	DefaultImageQuery = "请描述一下这张图片"
+	// 只发送文件时使用的默认问题
+	DefaultFileQuery = "请总结一下这个文件的内容"
+	// 发送该指令后, 下一个文件写入dify知识库
+	IngestCommand = "/kb"
 )
 
 var VoicePrefix = []string{}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 下载url对应的文件内容
+func DownloadFile(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file failed with status: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
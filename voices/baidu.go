@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -43,14 +42,20 @@ func BaiduVoiceInit() {
 		Expire:       time.Now(),
 	}
 }
+
+// 识别本地的16k pcm文件
 func (c *BaiduVoice) VoiceToText(filePath string) (string, error) {
-	url := "https://vop.baidu.com/server_api"
-	// 指定本地文件路径
 	// 读取文件内容
 	fileBytes, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		return "", err
 	}
+	return c.Recognize(fileBytes, "pcm")
+}
+
+// 识别语音, format为 pcm / wav / amr / m4a, 采样率需要为16000
+func (c *BaiduVoice) Recognize(fileBytes []byte, format string) (string, error) {
+	url := "https://vop.baidu.com/server_api"
 
 	// 计算文件大小（字节数）
 	fileSize := len(fileBytes)
@@ -64,7 +69,7 @@ func (c *BaiduVoice) VoiceToText(filePath string) (string, error) {
 
 	base64String := base64.StdEncoding.EncodeToString(fileBytes)
 	voiceData := VoiceData{
-		Format:  "pcm",
+		Format:  format,
 		Rate:    16000,
 		Channel: 1,
 		DevPid:  1537,
@@ -76,7 +81,7 @@ func (c *BaiduVoice) VoiceToText(filePath string) (string, error) {
 	// 将VoiceData结构体编码为JSON
 	jsonData, err := json.Marshal(voiceData)
 	if err != nil {
-		log.Fatalf("Error marshalling JSON: %v", err)
+		return "", err
 	}
 	payload := strings.NewReader(string(jsonData))
 	client := &http.Client{}
@@ -107,6 +112,9 @@ func (c *BaiduVoice) VoiceToText(filePath string) (string, error) {
 	if voiceRespBody.ErrNo != 0 {
 		return "", errors.New(voiceRespBody.ErrMsg)
 	}
+	if len(voiceRespBody.Result) == 0 {
+		return "", nil
+	}
 	return voiceRespBody.Result[0], nil
 }
 
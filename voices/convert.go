@@ -0,0 +1,74 @@
+package audio
+
+import (
+	"ding/utils"
+	"fmt"
+)
+
+const (
+	// 语音识别使用的采样率
+	TargetSampleRate = 16000
+)
+
+// 一段音频及其格式
+type Clip struct {
+	Data   []byte
+	Format string
+	Codec  string
+}
+
+// 从内存中的音频创建Clip
+func NewClip(data []byte) *Clip {
+	return &Clip{
+		Data:   data,
+		Format: DetectFormat(data),
+		Codec:  DetectCodec(data),
+	}
+}
+
+// 下载音频, 例如钉钉语音消息的下载链接
+func DownloadClip(url string) (*Clip, error) {
+	data, err := utils.DownloadFile(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewClip(data), nil
+}
+
+// 转换为16k单声道16位pcm
+// pcm编码的wav用纯Go转换, 其他格式需要ffmpeg, silk暂不支持
+func (c *Clip) PCM16k() ([]byte, error) {
+	if c.Format == FormatWAV {
+		if pcm, err := wavToPCM16k(c.Data); err == nil {
+			return pcm, nil
+		} else if FFmpegPath() == "" {
+			return nil, err
+		}
+	}
+	if c.Format == FormatSILK {
+		return nil, fmt.Errorf("silk audio is not supported")
+	}
+	return Transcode(c.Data, "-ar", fmt.Sprint(TargetSampleRate), "-ac", "1", "-acodec", "pcm_s16le", "-f", "s16le")
+}
+
+// 转换为16k单声道16位wav
+func (c *Clip) WAV16k() ([]byte, error) {
+	pcm, err := c.PCM16k()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeWAV(pcm, TargetSampleRate, 1), nil
+}
+
+// 用纯Go将wav转换为16k单声道pcm
+func wavToPCM16k(data []byte) ([]byte, error) {
+	info, err := parseWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := info.monoSamples()
+	if err != nil {
+		return nil, err
+	}
+	return encodePCM16(resample(samples, info.sampleRate, TargetSampleRate)), nil
+}
@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+var (
+	// AMR-NB各模式的帧长度, 不含帧头
+	amrFrameSizes = [16]int{12, 13, 15, 17, 19, 20, 26, 31, 5, 0, 0, 0, 0, 0, 0, 0}
+	// MPEG1 Layer III 的比特率, 单位kbps
+	mp3BitratesV1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	// MPEG2/2.5 Layer III 的比特率, 单位kbps
+	mp3BitratesV2  = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG1
+		2: {22050, 24000, 16000}, // MPEG2
+		0: {11025, 12000, 8000},  // MPEG2.5
+	}
+)
+
+var errUnknownFormat = errors.New("unknown audio format")
+
+// 计算音频时长, 支持mp3, wav和amr
+func Duration(data []byte) (time.Duration, error) {
+	switch DetectFormat(data) {
+	case FormatWAV:
+		return wavDuration(data)
+	case FormatMP3:
+		return mp3Duration(data)
+	case FormatAMR:
+		return amrDuration(data)
+	}
+	return 0, errUnknownFormat
+}
+
+// wav时长为data块大小除以每秒字节数
+func wavDuration(data []byte) (time.Duration, error) {
+	info, err := parseWAV(data)
+	if err != nil {
+		return 0, err
+	}
+	byteRate := info.sampleRate * info.channels * info.bitsPerSample / 8
+	if byteRate == 0 {
+		return 0, errors.New("invalid wav format")
+	}
+	return time.Duration(len(info.data)) * time.Second / time.Duration(byteRate), nil
+}
+
+// 逐帧累加mp3的采样数
+func mp3Duration(data []byte) (time.Duration, error) {
+	offset := 0
+	// 跳过ID3v2标签
+	if len(data) >= 10 && bytes.HasPrefix(data, []byte("ID3")) {
+		size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+		offset = 10 + size
+	}
+	var duration time.Duration
+	frames := 0
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF || data[offset+1]&0xE0 != 0xE0 {
+			offset++
+			continue
+		}
+		version := (data[offset+1] >> 3) & 0x03
+		layer := (data[offset+1] >> 1) & 0x03
+		bitrateIndex := data[offset+2] >> 4
+		sampleRateIndex := (data[offset+2] >> 2) & 0x03
+		padding := int((data[offset+2] >> 1) & 0x01)
+		sampleRates, ok := mp3SampleRates[version]
+		// 只支持Layer III
+		if !ok || layer != 1 || sampleRateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+			offset++
+			continue
+		}
+		sampleRate := sampleRates[sampleRateIndex]
+		samples := 576
+		bitrate := mp3BitratesV2[bitrateIndex]
+		if version == 3 {
+			samples = 1152
+			bitrate = mp3BitratesV1[bitrateIndex]
+		}
+		frameLength := samples/8*bitrate*1000/sampleRate + padding
+		duration += time.Duration(samples) * time.Second / time.Duration(sampleRate)
+		frames++
+		offset += frameLength
+	}
+	if frames == 0 {
+		return 0, errors.New("no mp3 frame found")
+	}
+	return duration, nil
+}
+
+// amr每帧20ms
+func amrDuration(data []byte) (time.Duration, error) {
+	offset := len(amrMagic)
+	frames := 0
+	for offset < len(data) {
+		frameType := (data[offset] >> 3) & 0x0F
+		offset += amrFrameSizes[frameType] + 1
+		frames++
+	}
+	return time.Duration(frames) * 20 * time.Millisecond, nil
+}
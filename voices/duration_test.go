@@ -0,0 +1,48 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	// 每帧的时长按采样数和采样率计算
+	mp3FrameV1 := time.Duration(1152) * time.Second / 44100
+	mp3FrameV2 := time.Duration(576) * time.Second / 22050
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want time.Duration
+	}{
+		{"wav 16k mono", testWAV(wavFormatPCM, 1, 16000, 16, make([]byte, 32000)), time.Second},
+		{"wav 44.1k stereo", testWAV(wavFormatPCM, 2, 44100, 16, make([]byte, 44100*4/2)), 500 * time.Millisecond},
+		{"wav 8k 8bit", testWAV(wavFormatPCM, 1, 8000, 8, make([]byte, 2000)), 250 * time.Millisecond},
+		{"mp3 mpeg1", testMP3(10, mp3HeaderV1, 417), 10 * mp3FrameV1},
+		{"mp3 mpeg2", testMP3(5, mp3HeaderV2, 208), 5 * mp3FrameV2},
+		{"mp3 with id3", testID3(100, testMP3(3, mp3HeaderV1, 417)), 3 * mp3FrameV1},
+		{"amr 12.2k", testAMR(7, 7, 7, 7, 7), 100 * time.Millisecond},
+		{"amr mixed modes", testAMR(0, 7, 8, 15), 80 * time.Millisecond},
+		{"amr empty", testAMR(), 0},
+	} {
+		got, err := Duration(tc.data)
+		if err != nil {
+			t.Errorf("Duration(%s): %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Duration(%s) = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDurationErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"unknown format": []byte("hello"),
+		"mp3 no frame":   testID3(10, nil),
+		"wav no data":    append([]byte("RIFF\x00\x00\x00\x00WAVE"), testChunk("fmt ", make([]byte, 16))...),
+	} {
+		if _, err := Duration(data); err == nil {
+			t.Errorf("Duration(%s) succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ffmpeg的路径, 优先使用 FFMPEG_PATH, 否则从PATH中查找, 找不到时返回空字符串
+func FFmpegPath() string {
+	if path := os.Getenv("FFMPEG_PATH"); path != "" {
+		return path
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// 用ffmpeg转码, 输入输出通过管道在内存中传递, args为输出参数, 需要包含 -f 指定输出格式
+func Transcode(data []byte, args ...string) ([]byte, error) {
+	path := FFmpegPath()
+	if path == "" {
+		return nil, fmt.Errorf("ffmpeg not found")
+	}
+	input := "pipe:0"
+	if DetectFormat(data) == FormatM4A {
+		// m4a的索引可能在文件末尾, 管道无法seek, 需要先写入临时文件
+		file, err := os.CreateTemp("", "audio-*.m4a")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.Write(data)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		input = file.Name()
+	}
+	cmdArgs := append([]string{"-hide_banner", "-loglevel", "error", "-i", input}, args...)
+	cmdArgs = append(cmdArgs, "pipe:1")
+	cmd := exec.Command(path, cmdArgs...)
+	if input == "pipe:0" {
+		cmd.Stdin = bytes.NewReader(data)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v, %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// 转换为钉钉语音消息使用的amr格式, maxSeconds大于0时截断到该时长
+func ToAMR(data []byte, maxSeconds int) ([]byte, error) {
+	args := []string{"-ar", "8000", "-ac", "1", "-c:a", "libopencore_amrnb", "-b:a", "12.2k"}
+	if maxSeconds > 0 {
+		args = append(args, "-t", fmt.Sprint(maxSeconds))
+	}
+	args = append(args, "-f", "amr")
+	return Transcode(data, args...)
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"bytes"
+)
+
+// 音频容器格式
+const (
+	FormatMP3     = "mp3"
+	FormatWAV     = "wav"
+	FormatAMR     = "amr"
+	FormatAMRWB   = "amr-wb"
+	FormatOGG     = "ogg"
+	FormatM4A     = "m4a"
+	FormatSILK    = "silk"
+	FormatPCM     = "pcm"
+	FormatUnknown = ""
+)
+
+// 音频编码
+const (
+	CodecPCM     = "pcm"
+	CodecOpus    = "opus"
+	CodecVorbis  = "vorbis"
+	CodecSpeex   = "speex"
+	CodecAMRNB   = "amr_nb"
+	CodecAMRWB   = "amr_wb"
+	CodecMP3     = "mp3"
+	CodecAAC     = "aac"
+	CodecSILK    = "silk"
+	CodecUnknown = ""
+)
+
+var (
+	amrMagic   = []byte("#!AMR\n")
+	amrWBMagic = []byte("#!AMR-WB\n")
+	silkMagic  = []byte("#!SILK_V3")
+)
+
+// 根据文件头判断音频格式
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, amrMagic):
+		return FormatAMR
+	case bytes.HasPrefix(data, amrWBMagic):
+		return FormatAMRWB
+	// 微信等使用的silk文件开头可能多一个0x02
+	case bytes.HasPrefix(data, silkMagic), len(data) > 1 && data[0] == 0x02 && bytes.HasPrefix(data[1:], silkMagic):
+		return FormatSILK
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return FormatWAV
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return FormatOGG
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return FormatM4A
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return FormatMP3
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return FormatMP3
+	}
+	return FormatUnknown
+}
+
+// 判断音频编码, ogg根据第一个数据包判断, m4a按aac处理
+func DetectCodec(data []byte) string {
+	switch DetectFormat(data) {
+	case FormatAMR:
+		return CodecAMRNB
+	case FormatAMRWB:
+		return CodecAMRWB
+	case FormatSILK:
+		return CodecSILK
+	case FormatMP3:
+		return CodecMP3
+	case FormatM4A:
+		return CodecAAC
+	case FormatWAV:
+		if format, err := parseWAV(data); err == nil && format.audioFormat == wavFormatPCM {
+			return CodecPCM
+		}
+	case FormatOGG:
+		return oggCodec(data)
+	}
+	return CodecUnknown
+}
+
+// ogg第一页的第一个数据包是编码的头信息
+func oggCodec(data []byte) string {
+	if len(data) < 27 {
+		return CodecUnknown
+	}
+	segments := int(data[26])
+	packet := 27 + segments
+	if packet >= len(data) {
+		return CodecUnknown
+	}
+	header := data[packet:]
+	switch {
+	case bytes.HasPrefix(header, []byte("OpusHead")):
+		return CodecOpus
+	case bytes.HasPrefix(header, []byte("\x01vorbis")):
+		return CodecVorbis
+	case bytes.HasPrefix(header, []byte("Speex   ")):
+		return CodecSpeex
+	}
+	return CodecUnknown
+}
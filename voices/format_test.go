@@ -0,0 +1,154 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// 生成wav文件, chunks为fmt和data之间的其他块
+func testWAV(audioFormat, channels, sampleRate, bitsPerSample int, data []byte, chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], uint16(audioFormat))
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], uint16(bitsPerSample))
+	body.Write(testChunk("fmt ", fmtChunk))
+	for _, chunk := range chunks {
+		body.Write(chunk)
+	}
+	body.Write(testChunk("data", data))
+	return append(append([]byte("RIFF"), le32(body.Len())...), body.Bytes()...)
+}
+
+// extensible格式的wav, 子格式为subFormat
+func testExtensibleWAV(subFormat, channels, sampleRate, bitsPerSample int, data []byte) []byte {
+	fmtChunk := make([]byte, 40)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], wavFormatExtensible)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], uint16(bitsPerSample))
+	binary.LittleEndian.PutUint16(fmtChunk[16:18], 22)
+	binary.LittleEndian.PutUint16(fmtChunk[24:26], uint16(subFormat))
+	body := append([]byte("WAVE"), testChunk("fmt ", fmtChunk)...)
+	body = append(body, testChunk("data", data)...)
+	return append(append([]byte("RIFF"), le32(len(body))...), body...)
+}
+
+// 生成wav块, 奇数长度的块后面补一个字节
+func testChunk(id string, body []byte) []byte {
+	chunk := append(append([]byte(id), le32(len(body))...), body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func le32(v int) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+// 生成n个相同的mp3帧, header为4字节帧头
+func testMP3(n int, header []byte, frameLength int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		frame := make([]byte, frameLength)
+		copy(frame, header)
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// 带ID3v2标签的mp3, 标签大小为syncsafe整数
+func testID3(size int, mp3 []byte) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	tag = append(tag, make([]byte, size)...)
+	return append(tag, mp3...)
+}
+
+// 生成amr文件, modes为每帧的模式
+func testAMR(modes ...int) []byte {
+	data := append([]byte{}, amrMagic...)
+	for _, mode := range modes {
+		frame := make([]byte, amrFrameSizes[mode]+1)
+		frame[0] = byte(mode<<3) | 0x04
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// 生成ogg第一页, 第一个数据包为packet
+func testOGG(packet []byte) []byte {
+	page := make([]byte, 27)
+	copy(page, "OggS")
+	page[26] = 1
+	page = append(page, byte(len(packet)))
+	return append(page, packet...)
+}
+
+var (
+	// MPEG1 Layer III, 128kbps, 44100Hz
+	mp3HeaderV1 = []byte{0xFF, 0xFB, 0x90, 0x00}
+	// MPEG2 Layer III, 64kbps, 22050Hz
+	mp3HeaderV2 = []byte{0xFF, 0xF3, 0x80, 0x00}
+)
+
+func TestDetectFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"wav", testWAV(wavFormatPCM, 1, 16000, 16, make([]byte, 32)), FormatWAV},
+		{"amr", testAMR(7, 7), FormatAMR},
+		{"amr-wb", []byte("#!AMR-WB\n\x00"), FormatAMRWB},
+		{"silk", []byte("#!SILK_V3\x00"), FormatSILK},
+		{"silk with prefix", []byte("\x02#!SILK_V3\x00"), FormatSILK},
+		{"ogg", testOGG([]byte("OpusHead")), FormatOGG},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00"), FormatM4A},
+		{"mp3 id3", testID3(10, testMP3(1, mp3HeaderV1, 417)), FormatMP3},
+		{"mp3 frame", testMP3(1, mp3HeaderV1, 417), FormatMP3},
+		{"empty", nil, FormatUnknown},
+		{"text", []byte("hello"), FormatUnknown},
+		{"riff not wave", []byte("RIFF\x00\x00\x00\x00AVI "), FormatUnknown},
+	} {
+		if got := DetectFormat(tc.data); got != tc.want {
+			t.Errorf("DetectFormat(%s) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"pcm wav", testWAV(wavFormatPCM, 1, 16000, 16, make([]byte, 32)), CodecPCM},
+		{"extensible pcm wav", testExtensibleWAV(wavFormatPCM, 2, 44100, 16, make([]byte, 32)), CodecPCM},
+		{"float wav", testWAV(wavFormatFloat, 1, 16000, 32, make([]byte, 32)), CodecUnknown},
+		{"amr", testAMR(7), CodecAMRNB},
+		{"amr-wb", []byte("#!AMR-WB\n\x00"), CodecAMRWB},
+		{"silk", []byte("#!SILK_V3\x00"), CodecSILK},
+		{"mp3", testMP3(1, mp3HeaderV1, 417), CodecMP3},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00"), CodecAAC},
+		{"ogg opus", testOGG([]byte("OpusHead\x01")), CodecOpus},
+		{"ogg vorbis", testOGG([]byte("\x01vorbis\x00")), CodecVorbis},
+		{"ogg speex", testOGG([]byte("Speex   1.2")), CodecSpeex},
+		{"ogg unknown", testOGG([]byte("FLAC")), CodecUnknown},
+		{"ogg truncated", []byte("OggS\x00"), CodecUnknown},
+		{"unknown", []byte("hello"), CodecUnknown},
+	} {
+		if got := DetectCodec(tc.data); got != tc.want {
+			t.Errorf("DetectCodec(%s) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// wav的编码类型
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wav文件的格式信息和采样数据
+type wavInfo struct {
+	audioFormat   int
+	channels      int
+	sampleRate    int
+	bitsPerSample int
+	data          []byte
+}
+
+// 解析wav文件, extensible格式按子格式处理
+func parseWAV(data []byte) (*wavInfo, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("not a wav file")
+	}
+	info := &wavInfo{}
+	offset := 12
+	for offset+8 <= len(data) {
+		chunkID := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := offset + 8
+		switch chunkID {
+		case "fmt ":
+			if body+16 > len(data) {
+				return nil, errors.New("invalid wav fmt chunk")
+			}
+			info.audioFormat = int(binary.LittleEndian.Uint16(data[body : body+2]))
+			info.channels = int(binary.LittleEndian.Uint16(data[body+2 : body+4]))
+			info.sampleRate = int(binary.LittleEndian.Uint32(data[body+4 : body+8]))
+			info.bitsPerSample = int(binary.LittleEndian.Uint16(data[body+14 : body+16]))
+			if info.audioFormat == wavFormatExtensible && chunkSize >= 26 && body+26 <= len(data) {
+				info.audioFormat = int(binary.LittleEndian.Uint16(data[body+24 : body+26]))
+			}
+		case "data":
+			if info.sampleRate == 0 || info.channels == 0 {
+				return nil, errors.New("wav fmt chunk not found")
+			}
+			// 流式生成的wav的data块大小可能不准确, 以实际长度为准
+			if chunkSize == 0 || body+chunkSize > len(data) {
+				chunkSize = len(data) - body
+			}
+			info.data = data[body : body+chunkSize]
+			return info, nil
+		}
+		// 块大小为奇数时有一个填充字节
+		offset = body + chunkSize + chunkSize%2
+	}
+	return nil, errors.New("wav data chunk not found")
+}
+
+// 将wav的采样解码为单声道的浮点采样, 多声道取平均值
+func (info *wavInfo) monoSamples() ([]float64, error) {
+	bytesPerSample := info.bitsPerSample / 8
+	if bytesPerSample == 0 {
+		return nil, fmt.Errorf("unsupported wav bits per sample: %d", info.bitsPerSample)
+	}
+	decode, err := info.sampleDecoder()
+	if err != nil {
+		return nil, err
+	}
+	frameSize := bytesPerSample * info.channels
+	frames := len(info.data) / frameSize
+	samples := make([]float64, frames)
+	for i := 0; i < frames; i++ {
+		sum := 0.0
+		for c := 0; c < info.channels; c++ {
+			start := i*frameSize + c*bytesPerSample
+			sum += decode(info.data[start : start+bytesPerSample])
+		}
+		samples[i] = sum / float64(info.channels)
+	}
+	return samples, nil
+}
+
+// 单个采样的解码函数, 结果范围为[-1, 1]
+func (info *wavInfo) sampleDecoder() (func([]byte) float64, error) {
+	switch {
+	case info.audioFormat == wavFormatPCM && info.bitsPerSample == 8:
+		// 8位采样为无符号数
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case info.audioFormat == wavFormatPCM && info.bitsPerSample == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }, nil
+	case info.audioFormat == wavFormatPCM && info.bitsPerSample == 24:
+		return func(b []byte) float64 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / 8388608
+		}, nil
+	case info.audioFormat == wavFormatPCM && info.bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648 }, nil
+	case info.audioFormat == wavFormatFloat && info.bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	case info.audioFormat == wavFormatFloat && info.bitsPerSample == 64:
+		return func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported wav format: %d, %d bits", info.audioFormat, info.bitsPerSample)
+}
+
+// 线性插值重采样
+func resample(samples []float64, from, to int) []float64 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	length := int(int64(len(samples)) * int64(to) / int64(from))
+	out := make([]float64, length)
+	ratio := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * ratio
+		index := int(pos)
+		if index+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(index)
+		out[i] = samples[index]*(1-frac) + samples[index+1]*frac
+	}
+	return out
+}
+
+// 编码为16位小端pcm
+func encodePCM16(samples []float64) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		sample = math.Max(-1, math.Min(1, sample))
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(math.Round(sample*32767))))
+	}
+	return out
+}
+
+// 为16位pcm加上wav文件头
+func EncodeWAV(pcm []byte, sampleRate, channels int) []byte {
+	const bitsPerSample = 16
+	blockAlign := channels * bitsPerSample / 8
+	out := make([]byte, 44+len(pcm))
+	copy(out[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(36+len(pcm)))
+	copy(out[8:12], "WAVE")
+	copy(out[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:20], 16)
+	binary.LittleEndian.PutUint16(out[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(out[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(out[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(out[28:32], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(out[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(out[34:36], bitsPerSample)
+	copy(out[36:40], "data")
+	binary.LittleEndian.PutUint32(out[40:44], uint32(len(pcm)))
+	copy(out[44:], pcm)
+	return out
+}
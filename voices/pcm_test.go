@@ -0,0 +1,125 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseWAV(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, tc := range []struct {
+		name       string
+		wav        []byte
+		format     int
+		channels   int
+		sampleRate int
+		bits       int
+		dataLen    int
+	}{
+		{"pcm16 stereo", testWAV(wavFormatPCM, 2, 44100, 16, data), wavFormatPCM, 2, 44100, 16, 8},
+		{"float32 mono", testWAV(wavFormatFloat, 1, 16000, 32, data), wavFormatFloat, 1, 16000, 32, 8},
+		{"extensible", testExtensibleWAV(wavFormatPCM, 1, 48000, 24, data[:6]), wavFormatPCM, 1, 48000, 24, 6},
+		{"odd chunk padding", testWAV(wavFormatPCM, 1, 8000, 8, data, testChunk("LIST", []byte{1, 2, 3})), wavFormatPCM, 1, 8000, 8, 8},
+		{"encoded by EncodeWAV", EncodeWAV(data, 16000, 1), wavFormatPCM, 1, 16000, 16, 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := parseWAV(tc.wav)
+			if err != nil {
+				t.Fatalf("parseWAV: %v", err)
+			}
+			if info.audioFormat != tc.format || info.channels != tc.channels || info.sampleRate != tc.sampleRate || info.bitsPerSample != tc.bits {
+				t.Errorf("parseWAV = format %d, %d channels, %dHz, %d bits, want %d, %d, %d, %d",
+					info.audioFormat, info.channels, info.sampleRate, info.bitsPerSample, tc.format, tc.channels, tc.sampleRate, tc.bits)
+			}
+			if len(info.data) != tc.dataLen {
+				t.Errorf("len(data) = %d, want %d", len(info.data), tc.dataLen)
+			}
+		})
+	}
+}
+
+func TestParseWAVStreamingSize(t *testing.T) {
+	// 流式生成的wav的data块大小为0或超过实际长度
+	for _, size := range []int{0, 1000} {
+		wav := testWAV(wavFormatPCM, 1, 16000, 16, make([]byte, 10))
+		copy(wav[len(wav)-14:], le32(size))
+		info, err := parseWAV(wav)
+		if err != nil {
+			t.Fatalf("parseWAV with data size %d: %v", size, err)
+		}
+		if len(info.data) != 10 {
+			t.Errorf("len(data) with data size %d = %d, want 10", size, len(info.data))
+		}
+	}
+}
+
+func TestParseWAVErrors(t *testing.T) {
+	noFmt := append([]byte("RIFF\x00\x00\x00\x00WAVE"), testChunk("data", make([]byte, 4))...)
+	noData := append([]byte("RIFF\x00\x00\x00\x00WAVE"), testChunk("fmt ", make([]byte, 16))...)
+	shortFmt := append([]byte("RIFF\x00\x00\x00\x00WAVE"), []byte("fmt \x10\x00\x00\x00\x01\x00")...)
+	for name, data := range map[string][]byte{
+		"not wav":   []byte("hello world!"),
+		"no fmt":    noFmt,
+		"no data":   noData,
+		"short fmt": shortFmt,
+	} {
+		if _, err := parseWAV(data); err == nil {
+			t.Errorf("parseWAV(%s) succeeded, want error", name)
+		}
+	}
+}
+
+func TestMonoSamples(t *testing.T) {
+	// 左右声道分别为最大值和0, 平均后为0.5
+	pcm := encodePCM16([]float64{1, 0, -1, 0})
+	info, err := parseWAV(testWAV(wavFormatPCM, 2, 16000, 16, pcm))
+	if err != nil {
+		t.Fatalf("parseWAV: %v", err)
+	}
+	samples, err := info.monoSamples()
+	if err != nil {
+		t.Fatalf("monoSamples: %v", err)
+	}
+	want := []float64{0.5, -0.5}
+	if len(samples) != len(want) {
+		t.Fatalf("len(samples) = %d, want %d", len(samples), len(want))
+	}
+	for i := range want {
+		if math.Abs(samples[i]-want[i]) > 0.001 {
+			t.Errorf("samples[%d] = %f, want %f", i, samples[i], want[i])
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		samples  []float64
+		from, to int
+		want     []float64
+	}{
+		{"same rate", []float64{0.1, 0.2}, 16000, 16000, []float64{0.1, 0.2}},
+		{"empty", nil, 44100, 16000, nil},
+		{"upsample", []float64{0, 1}, 8000, 16000, []float64{0, 0.5, 1, 1}},
+		{"downsample", []float64{0, 0.25, 0.5, 0.75}, 16000, 8000, []float64{0, 0.5}},
+		{"interpolate", []float64{0, 1, 0}, 3, 2, []float64{0, 0.5}},
+	} {
+		got := resample(tc.samples, tc.from, tc.to)
+		if len(got) != len(tc.want) {
+			t.Errorf("resample(%s) length = %d, want %d", tc.name, len(got), len(tc.want))
+			continue
+		}
+		for i := range tc.want {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("resample(%s)[%d] = %f, want %f", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestResampleLength(t *testing.T) {
+	// 1秒44.1k的采样转为16k后为16000个采样
+	if got := len(resample(make([]float64, 44100), 44100, 16000)); got != 16000 {
+		t.Errorf("len(resample) = %d, want 16000", got)
+	}
+}
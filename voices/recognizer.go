@@ -0,0 +1,11 @@
+package audio
+
+// 语音识别, data为完整的音频内容, format为音频格式, 例如 amr / wav / pcm
+type SpeechRecognizer interface {
+	Recognize(data []byte, format string) (string, error)
+}
+
+var (
+	_ SpeechRecognizer = (*BaiduVoice)(nil)
+	_ SpeechRecognizer = (*RequestApi)(nil)
+)
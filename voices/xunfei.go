@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -26,6 +27,8 @@ type RequestApi struct {
 	AppID          string
 	SecretKey      string
 	UploadFilePath string
+	UploadFileName string // 设置UploadData时使用的文件名
+	UploadData     []byte // 内存中的音频, 设置后不再读取UploadFilePath
 	Timestamp      string
 	Signa          string
 }
@@ -149,19 +152,17 @@ func (api *RequestApi) getSigna() string {
 
 func (api *RequestApi) upload() (map[string]interface{}, error) {
 	fmt.Println("上传部分：")
-	filePath := api.UploadFilePath
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, err
+	data := api.UploadData
+	fileName := api.UploadFileName
+	if data == nil {
+		var err error
+		data, err = ioutil.ReadFile(api.UploadFilePath)
+		if err != nil {
+			return nil, err
+		}
+		fileName = filepath.Base(api.UploadFilePath)
 	}
-	fileLen := fileInfo.Size()
-	fileName := fileInfo.Name()
+	fileLen := int64(len(data))
 
 	param := url.Values{}
 	param.Add("appId", api.AppID)
@@ -175,11 +176,6 @@ func (api *RequestApi) upload() (map[string]interface{}, error) {
 	fmt.Println("upload参数：", param)
 	fmt.Println("upload_url:", url)
 
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, err
-	}
-
 	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
@@ -201,80 +197,158 @@ func (api *RequestApi) upload() (map[string]interface{}, error) {
 	return result, nil
 }
 
-func (api *RequestApi) getResult() (*SpeechResult, error) {
-	uploadResp, err := api.upload()
-	if err != nil {
-		return nil, err
+// 转写订单的状态
+const (
+	XunfeiStatusCreated    = 0
+	XunfeiStatusProcessing = 3
+	XunfeiStatusDone       = 4
+	XunfeiStatusFailed     = -1
+)
+
+const (
+	// 等待转写结果时的轮询间隔, 每次增加一半, 不超过最大间隔
+	xunfeiPollInterval    = 2 * time.Second
+	xunfeiMaxPollInterval = 30 * time.Second
+	// 同步转写录音文件的最长等待时间
+	xunfeiWaitTimeout = 30 * time.Minute
+	// 同步识别语音消息的最长等待时间, 识别在消息队列的消费者中进行, 不能长时间占用
+	xunfeiRecognizeTimeout = 30 * time.Second
+)
+
+// 生成本次请求的时间戳和签名
+func (api *RequestApi) signed() *RequestApi {
+	request := &RequestApi{
+		AppID:     api.AppID,
+		SecretKey: api.SecretKey,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
 	}
+	request.Signa = request.getSigna()
+	return request
+}
 
-	orderId := uploadResp["content"].(map[string]interface{})["orderId"].(string)
+// 上传音频创建转写订单, 返回订单id和讯飞预计的转写耗时
+func (api *RequestApi) Submit(data []byte, fileName string) (string, time.Duration, error) {
+	request := api.signed()
+	request.UploadData = data
+	request.UploadFileName = fileName
+	uploadResp, err := request.upload()
+	if err != nil {
+		return "", 0, err
+	}
+	content, _ := uploadResp["content"].(map[string]interface{})
+	orderId, _ := content["orderId"].(string)
+	if orderId == "" {
+		return "", 0, fmt.Errorf("xunfei upload failed: %v", uploadResp["descInfo"])
+	}
+	estimate, _ := content["taskEstimateTime"].(float64)
+	return orderId, time.Duration(estimate) * time.Millisecond, nil
+}
 
+// 查询一次转写订单的状态和结果
+func (api *RequestApi) Query(orderId string) (*SpeechResult, error) {
+	request := api.signed()
 	param := url.Values{}
-	param.Add("appId", api.AppID)
-	param.Add("signa", api.Signa)
-	param.Add("ts", api.Timestamp)
+	param.Add("appId", request.AppID)
+	param.Add("signa", request.Signa)
+	param.Add("ts", request.Timestamp)
 	param.Add("orderId", orderId)
 	param.Add("resultType", "transfer,predict")
 
-	url := lfasrHost + apiGetResult + "?" + param.Encode()
-	fmt.Println("查询部分：")
-	fmt.Println("get result参数：", param)
+	resp, err := http.Post(lfasrHost+apiGetResult+"?"+param.Encode(), "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	//var result map[string]interface{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	var result SpeechResult
-	status := 3
-
-	for status == 3 {
-		resp, err := http.Post(url, "application/json", nil)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
 
-		body, err := ioutil.ReadAll(resp.Body)
+// 等待转写完成, 轮询间隔逐渐增加
+func (api *RequestApi) Wait(orderId string, timeout time.Duration) (*SpeechResult, error) {
+	deadline := time.Now().Add(timeout)
+	interval := xunfeiPollInterval
+	for {
+		result, err := api.Query(orderId)
 		if err != nil {
 			return nil, err
 		}
-
-		err = json.Unmarshal(body, &result)
-		if err != nil {
-			return nil, err
+		switch result.Content.OrderInfo.Status {
+		case XunfeiStatusDone:
+			return result, nil
+		case XunfeiStatusFailed:
+			return nil, fmt.Errorf("xunfei transcription failed: %d %s", result.Content.OrderInfo.FailType, result.DescInfo)
 		}
-
-		fmt.Println(result)
-		status = result.Content.OrderInfo.Status
-		if status == 4 {
-			break
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("xunfei transcription timeout")
 		}
+		time.Sleep(interval)
+		interval = NextPollInterval(interval, xunfeiMaxPollInterval)
+	}
+}
 
-		time.Sleep(5 * time.Second)
+// 下一次轮询的间隔, 每次增加一半, 不超过max
+func NextPollInterval(interval, max time.Duration) time.Duration {
+	interval += interval / 2
+	if interval > max {
+		return max
 	}
+	return interval
+}
 
-	fmt.Println("get_result resp:", result)
-	return &result, nil
+// 转写结果中的文本
+func (result *SpeechResult) Text() (string, error) {
+	return extractTextFromResult(result.Content.OrderResult)
 }
 
-func XunfeiHandler() {
-	ts := strconv.FormatInt(time.Now().Unix(), 10)
-	api := RequestApi{
-		AppID:          os.Getenv("XUNFEI_APPID"),
-		SecretKey:      os.Getenv("XUNFEI_SecretKey"),
-		UploadFilePath: "audio/aigei_com.wav",
-		Timestamp:      ts,
-		Signa:          "",
+// 从环境变量创建讯飞录音文件转写客户端
+func NewXunfeiRecognizer() *RequestApi {
+	return &RequestApi{
+		AppID:     os.Getenv("XUNFEI_APPID"),
+		SecretKey: os.Getenv("XUNFEI_SecretKey"),
 	}
-	api.Signa = api.getSigna()
+}
 
-	maps, err := api.getResult()
+// 同步识别内存中的短语音, 讯飞录音文件转写需要排队, 超过30秒没有结果时返回错误
+// 长录音应使用 Submit 和 Query 在后台转写
+func (api *RequestApi) Recognize(data []byte, format string) (string, error) {
+	orderId, _, err := api.Submit(data, "voice."+format)
 	if err != nil {
-		fmt.Println("Error:", err)
+		return "", err
 	}
-	str := maps.Content.OrderResult
-	text, err := extractTextFromResult(str)
+	result, err := api.Wait(orderId, xunfeiRecognizeTimeout)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return
+		return "", err
 	}
+	return result.Text()
+}
 
+// 转写本地的录音文件
+func XunfeiHandler(filePath string) (string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	api := NewXunfeiRecognizer()
+	orderId, _, err := api.Submit(data, filepath.Base(filePath))
+	if err != nil {
+		return "", err
+	}
+	result, err := api.Wait(orderId, xunfeiWaitTimeout)
+	if err != nil {
+		return "", err
+	}
+	text, err := result.Text()
+	if err != nil {
+		return "", err
+	}
 	fmt.Println("Extracted Text:", text)
+	return text, nil
 }